@@ -0,0 +1,266 @@
+package hermes
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strconv"
+)
+
+// An Admin is an HTTP server to watch and control a running crawl. It serves a dashboard on
+// "/" and a JSON API:
+//
+//	GET  /api/progress                  the Progress of the crawl
+//	GET  /api/frontier?q=&limit=        the links queued, filtered by the q substring
+//	POST /api/frontier/add?url=         add a link to the frontier
+//	POST /api/frontier/remove?url=      remove a link from the frontier
+//	POST /api/hosts/ban?host=           ban a host
+//	POST /api/pause, /api/unpause, /api/stop, /api/cancel
+//
+// The pprof profiles are mounted on /debug/pprof/. By default the Admin has no authentication,
+// only serve it on a private address or set its Authorize hook and Token.
+type Admin struct {
+	// The Authorize hook is called with every request, a request it returns false for is answered
+	// with 401 Unauthorized and a Basic challenge. It is the place to check a password with
+	// req.BasicAuth.
+	Authorize func(req *http.Request) bool
+
+	// The Token, if set, must be sent with the POST requests, in the X-Hermes-Token header or the
+	// token form value. The dashboard's forms include it, so another site can't make a browser
+	// that has the dashboard open control the crawl.
+	Token string
+
+	r   *Runner
+	mux *http.ServeMux
+}
+
+// NewAdmin returns an Admin for the Runner. It can be started before the crawl, and serves
+// every crawl of the Runner.
+func NewAdmin(r *Runner) *Admin {
+	a := &Admin{r: r, mux: http.NewServeMux()}
+	a.mux.HandleFunc("/", a.dashboard)
+	a.mux.HandleFunc("/api/progress", a.progress)
+	a.mux.HandleFunc("/api/frontier", a.frontier)
+	a.mux.HandleFunc("/api/frontier/add", a.post(a.add))
+	a.mux.HandleFunc("/api/frontier/remove", a.post(a.remove))
+	a.mux.HandleFunc("/api/hosts/ban", a.post(a.ban))
+	a.mux.HandleFunc("/api/pause", a.post(func(req *http.Request) (interface{}, error) { return nil, r.Pause() }))
+	a.mux.HandleFunc("/api/unpause", a.post(func(req *http.Request) (interface{}, error) { return nil, r.Unpause() }))
+	a.mux.HandleFunc("/api/stop", a.post(func(req *http.Request) (interface{}, error) { return nil, r.Stop() }))
+	a.mux.HandleFunc("/api/cancel", a.post(func(req *http.Request) (interface{}, error) { return nil, r.Cancel() }))
+
+	a.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return a
+}
+
+// ListenAndServe serves the Admin on the address. It blocks like http.ListenAndServe.
+func (a *Admin) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, a)
+}
+
+// ServeHTTP serves the dashboard, the API and the pprof profiles.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if a.Authorize != nil && !a.Authorize(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="hermes"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	a.mux.ServeHTTP(w, req)
+}
+
+// progress writes the Progress of the crawl.
+func (a *Admin) progress(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, a.r.Progress())
+}
+
+// frontier writes the links of the frontier that contain the q parameter.
+func (a *Admin) frontier(w http.ResponseWriter, req *http.Request) {
+	limit, _ := strconv.Atoi(req.FormValue("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	writeJSON(w, http.StatusOK, a.r.Frontier(req.FormValue("q"), limit))
+}
+
+// add adds the url parameter to the frontier.
+func (a *Admin) add(req *http.Request) (interface{}, error) {
+	u, err := adminURL(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, a.r.AddURL(u)
+}
+
+// remove removes the url parameter from the frontier.
+func (a *Admin) remove(req *http.Request) (interface{}, error) {
+	u, err := adminURL(req)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]bool{"removed": a.r.RemoveURL(u)}, nil
+}
+
+// ban bans the host parameter.
+func (a *Admin) ban(req *http.Request) (interface{}, error) {
+	host := req.FormValue("host")
+	if host == "" {
+		return nil, ErrEmptyHost
+	}
+	n, err := a.r.BanHost(host)
+	return map[string]int{"removed": n}, err
+}
+
+// post returns a handler that only accepts POST requests with the Token and writes the result of
+// the action as JSON. The dashboard's forms are redirected back to the dashboard, with the error
+// in the error parameter.
+func (a *Admin) post(action func(req *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		if !a.validToken(req) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "invalid token"})
+			return
+		}
+		v, err := action(req)
+		if req.FormValue("redirect") != "" {
+			to := "/"
+			if err != nil {
+				to += "?error=" + url.QueryEscape(err.Error())
+			}
+			http.Redirect(w, req, to, http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			status := http.StatusBadRequest
+			if err == ErrNotCrawling {
+				status = http.StatusConflict
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		if v == nil {
+			v = map[string]bool{"ok": true}
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// validToken returns true if the Admin has no Token or the request has it.
+func (a *Admin) validToken(req *http.Request) bool {
+	if a.Token == "" {
+		return true
+	}
+	token := req.Header.Get("X-Hermes-Token")
+	if token == "" {
+		token = req.FormValue("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+// dashboard serves the HTML dashboard.
+func (a *Admin) dashboard(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	q := req.FormValue("q")
+	refresh := "/"
+	if q != "" {
+		refresh += "?q=" + url.QueryEscape(q)
+	}
+	data := struct {
+		Progress Progress
+		Query    string
+		Error    string
+		Token    string
+		Refresh  string
+		Frontier []CheckpointLink
+	}{a.r.Progress(), q, req.FormValue("error"), a.Token, refresh, a.r.Frontier(q, 100)}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardTemplate.Execute(w, data)
+}
+
+// adminURL parses the url parameter of a request.
+func adminURL(req *http.Request) (*url.URL, error) {
+	u, err := url.Parse(req.FormValue("url"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ErrUnsupportedScheme
+	}
+	if u.Host == "" {
+		return nil, ErrEmptyHost
+	}
+	return u, nil
+}
+
+// writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5; url={{.Refresh}}">
+<title>hermes</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px; text-align: left; }
+form { display: inline; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>hermes</h1>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+{{with .Progress}}
+<table>
+<tr><th>Status</th><td>{{if not .Crawling}}idle{{else if .Paused}}paused{{else}}crawling{{end}}</td></tr>
+<tr><th>Started</th><td>{{if .Crawling}}{{.Start.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+<tr><th>Fetched</th><td>{{.Fetched}}</td></tr>
+<tr><th>Documents</th><td>{{.Documents}}</td></tr>
+<tr><th>Queued</th><td>{{.Queued}}</td></tr>
+<tr><th>Errors</th><td>{{.Errors}}</td></tr>
+<tr><th>Active hosts</th><td>{{.Hosts}}</td></tr>
+<tr><th>Banned hosts</th><td>{{range .Banned}}{{.}} {{end}}</td></tr>
+</table>
+{{end}}
+<p>
+<form method="post" action="/api/pause"><input type="hidden" name="redirect" value="1">{{template "token" $}}<button>Pause</button></form>
+<form method="post" action="/api/unpause"><input type="hidden" name="redirect" value="1">{{template "token" $}}<button>Unpause</button></form>
+<form method="post" action="/api/stop"><input type="hidden" name="redirect" value="1">{{template "token" $}}<button>Stop</button></form>
+<form method="post" action="/api/cancel"><input type="hidden" name="redirect" value="1">{{template "token" $}}<button>Cancel</button></form>
+</p>
+<p>
+<form method="post" action="/api/frontier/add"><input type="hidden" name="redirect" value="1">{{template "token" $}}<input name="url" placeholder="https://"><button>Add URL</button></form>
+<form method="post" action="/api/hosts/ban"><input type="hidden" name="redirect" value="1">{{template "token" $}}<input name="host" placeholder="host"><button>Ban host</button></form>
+</p>
+<h2>Frontier</h2>
+<form method="get" action="/"><input name="q" value="{{.Query}}" placeholder="search"><button>Search</button></form>
+<table>
+<tr><th>Method</th><th>URL</th><th>Depth</th><th></th></tr>
+{{range .Frontier}}
+<tr><td>{{.Method}}</td><td>{{.URL}}</td><td>{{.Depth}}</td>
+<td><form method="post" action="/api/frontier/remove"><input type="hidden" name="redirect" value="1">{{template "token" $}}<input type="hidden" name="url" value="{{.URL}}"><button>Remove</button></form></td></tr>
+{{end}}
+</table>
+<p><a href="/debug/pprof/">pprof</a></p>
+</body>
+</html>
+{{define "token"}}{{with .Token}}<input type="hidden" name="token" value="{{.}}">{{end}}{{end}}`))
@@ -0,0 +1,286 @@
+package hermes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/PuerkitoBio/fetchbot"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/context"
+)
+
+// ErrLoginFailed defines a login request that was rejected or that landed back on the login page
+var ErrLoginFailed = errors.New("login failed")
+
+type (
+	// An Auth defines how a Runner authenticates to the sites it crawls. Before the crawl starts
+	// the Runner logs in by posting the Form to the LoginURL, or by replaying a configured request,
+	// and keeps the session cookies in the cookie jar of its HTTPConfig. When a page redirects to
+	// the login page (or answers 401) the session has expired: the Runner logs in again and
+	// fetches the page once more. The Credentials are sent as an Authorization header instead,
+	// for the sites that use basic or bearer authentication.
+	Auth struct {
+		// The LoginPage is the page of the login form. If it is set it is fetched before logging in and the
+		// hidden inputs of its form (i.e. a CSRF token) are added to the Form. A redirect to it means that the
+		// session has expired.
+		LoginPage string
+
+		// The LoginURL is the URL the login request is sent to. If it is empty the action of the LoginPage's
+		// form is used. A redirect to it means that the session has expired.
+		LoginURL string
+
+		// The Method of the login request, POST if it is empty.
+		Method string
+
+		// The Form holds the form fields of the login request (i.e. username and password). If it is nil the
+		// Body is sent as it is.
+		Form url.Values
+
+		// The Body and Header of a replayed login request (i.e. a JSON login API).
+		Body   string
+		Header http.Header
+
+		// The LogoutURL is never enqueued, so that the crawl doesn't end its own session.
+		LogoutURL string
+
+		// Expired returns true if the response means that the session has expired. If it is nil a redirect to
+		// the LoginPage or LoginURL, or a 401 response, means that the session has expired.
+		Expired func(*http.Response) bool
+
+		// The Credentials are sent to the host of the Runner's URL.
+		Credentials *Credentials
+
+		// The HostCredentials are sent to specific hosts, keyed by host name (i.e. "wiki.example.com").
+		HostCredentials map[string]*Credentials
+	}
+
+	// Credentials are sent as an Authorization header: a bearer Token if it is set, otherwise the
+	// Username and Password with basic authentication.
+	Credentials struct {
+		Username string
+		Password string
+		Token    string
+	}
+)
+
+// hasLogin returns true if the Auth defines a login request.
+func (a *Auth) hasLogin() bool {
+	return a.LoginURL != "" || a.LoginPage != ""
+}
+
+// login authenticates with the login request of the Auth, using the doer that the cookies of the
+// session are stored by.
+func (r *Runner) login(doer fetchbot.Doer) error {
+	a := r.Auth
+	form := url.Values{}
+	for k, v := range a.Form {
+		form[k] = v
+	}
+	loginURL := a.LoginURL
+
+	if a.LoginPage != "" {
+		action, hidden, err := r.loginForm(doer)
+		if err != nil {
+			return err
+		}
+		if loginURL == "" {
+			loginURL = action
+		}
+		for k, v := range hidden {
+			if _, ok := form[k]; !ok {
+				form[k] = v
+			}
+		}
+	}
+
+	method := a.Method
+	if method == "" {
+		method = "POST"
+	}
+	body := a.Body
+	if a.Form != nil {
+		body = form.Encode()
+	}
+	req, err := http.NewRequest(method, loginURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range a.Header {
+		req.Header[k] = v
+	}
+	if a.Form != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", r.UserAgent)
+
+	res, err := doer.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	// a failed login usually shows the login form again
+	if res.StatusCode >= 400 || (a.LoginPage != "" && r.isLoginPage(res.Request.URL)) {
+		return fmt.Errorf("%s %s - %s: %w", method, loginURL, res.Status, ErrLoginFailed)
+	}
+	r.log().Info("logged in", requestFields(method, req.URL, res.StatusCode, nil))
+	return nil
+}
+
+// loginForm fetches the LoginPage and returns the resolved action and the hidden inputs of its
+// login form, which is the form with a password input or the first form of the page.
+func (r *Runner) loginForm(doer fetchbot.Doer) (string, url.Values, error) {
+	req, err := http.NewRequest("GET", r.Auth.LoginPage, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("User-Agent", r.UserAgent)
+	res, err := doer.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+	doc, err := parseResponse(res)
+	if err != nil {
+		return "", nil, err
+	}
+
+	form := doc.Find("form:has(input[type=password])").First()
+	if form.Length() == 0 {
+		form = doc.Find("form").First()
+	}
+	hidden := url.Values{}
+	form.Find("input[type=hidden][name]").Each(func(i int, s *goquery.Selection) {
+		name, _ := s.Attr("name")
+		value, _ := s.Attr("value")
+		hidden.Add(name, value)
+	})
+
+	action := res.Request.URL
+	if href, ok := form.Attr("action"); ok {
+		if u, err := resolveLink(action, href); err == nil {
+			action = u
+		}
+	}
+	return action.String(), hidden, nil
+}
+
+// isLoginPage returns true if u is the LoginPage or the LoginURL of the Auth.
+func (r *Runner) isLoginPage(u *url.URL) bool {
+	key := r.canonicalizer().Key(u)
+	for _, login := range []string{r.Auth.LoginPage, r.Auth.LoginURL} {
+		if login == "" {
+			continue
+		}
+		if l, err := url.Parse(login); err == nil && r.canonicalizer().Key(l) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// isLogout returns true if u is the LogoutURL of the Runner's Auth.
+func (r *Runner) isLogout(u *url.URL) bool {
+	if r.Auth == nil || r.Auth.LogoutURL == "" {
+		return false
+	}
+	l, err := url.Parse(r.Auth.LogoutURL)
+	return err == nil && r.canonicalizer().Key(l) == r.canonicalizer().Key(u)
+}
+
+// sessionExpired returns true if the response to cmd means that the session has expired.
+func (r *Runner) sessionExpired(cmd fetchbot.Command, res *http.Response) bool {
+	if r.Auth.Expired != nil {
+		return r.Auth.Expired(res)
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	return res.Request != nil && res.Request.URL != nil &&
+		r.isLoginPage(res.Request.URL) && !r.isLoginPage(cmd.URL())
+}
+
+// authHandler logs in again when a response shows that the session has expired, and enqueues
+// the link once more. A link is only fetched again once, so that a login that doesn't stick
+// can't loop forever.
+func (r *Runner) authHandler(doer fetchbot.Doer, wrapped fetchbot.Handler) fetchbot.Handler {
+	return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		c, ok := ctx.Cmd.(*linkCmd)
+		if !ok || err != nil || !r.sessionExpired(c, res) {
+			wrapped.Handle(ctx, res, err)
+			return
+		}
+		if c.reauth {
+			// the page still asks for a login, don't scrape the login page in its place
+			r.log().Error("session still expired", cmdFields(c, res.StatusCode, ErrLoginFailed))
+			r.fetched(c)
+			r.fail(c, StageLogin, ErrLoginFailed, res.StatusCode, nil)
+			return
+		}
+
+		r.log().Warn("session expired", cmdFields(c, res.StatusCode, nil))
+		if err := r.relogin(doer, res); err != nil {
+			r.log().Error("login failed", cmdFields(c, res.StatusCode, err))
+			wrapped.Handle(ctx, res, err)
+			return
+		}
+
+		next := c.again()
+		next.reauth = true
+		if err := r.send(ctx.Q, next); err != nil {
+			r.log().Error("enqueue failed", cmdFields(c, 0, err))
+		}
+	})
+}
+
+// relogin logs in again, unless the session was renewed since the request of res was sent,
+// which happens when several hosts share the session.
+func (r *Runner) relogin(doer fetchbot.Doer, res *http.Response) error {
+	r.amu.Lock()
+	defer r.amu.Unlock()
+	if gen, ok := res.Request.Context().Value(loginGenKey{}).(int64); ok && gen != atomic.LoadInt64(&r.logins) {
+		return nil
+	}
+	if err := r.login(doer); err != nil {
+		return err
+	}
+	atomic.AddInt64(&r.logins, 1)
+	return nil
+}
+
+// loginGenKey is the context key of the login a request was sent with.
+type loginGenKey struct{}
+
+// authDoer is a fetchbot Doer that adds the Authorization header of the Auth's Credentials to
+// the requests of the wrapped Doer, and tags them with the current login.
+type authDoer struct {
+	r    *Runner
+	doer fetchbot.Doer
+}
+
+// Do adds the credentials of the request's host and does it.
+func (d *authDoer) Do(req *http.Request) (*http.Response, error) {
+	a := d.r.Auth
+	c := a.HostCredentials[req.URL.Host]
+	if c == nil {
+		c = a.HostCredentials[hostname(req.URL.Host)]
+	}
+	if c == nil && req.URL.Host == d.r.URL.Host {
+		c = a.Credentials
+	}
+	if c != nil && req.Header.Get("Authorization") == "" {
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		} else {
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+	}
+	if a.hasLogin() {
+		req = req.WithContext(context.WithValue(req.Context(), loginGenKey{}, atomic.LoadInt64(&d.r.logins)))
+	}
+	return d.doer.Do(req)
+}
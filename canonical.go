@@ -0,0 +1,272 @@
+package hermes
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultStripParams are the query parameters removed by the default Canonicalizer. These are
+// tracking and session parameters that don't change the content of a page. A trailing '*' matches
+// any parameter with that prefix. Short names like "sid" or "id" are left out on purpose: too many
+// sites use them for content.
+var DefaultStripParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"_ga",
+	"jsessionid",
+	"phpsessid",
+	"aspsessionid*",
+	"sessionid",
+}
+
+// A Canonicalizer turns a URL into its canonical form so that the different spellings of the same
+// page are detected as duplicates. The scheme and host are always lowercased, the host is converted
+// to its punycode form, default ports are removed, an empty path becomes "/", percent-encoding is
+// normalized and the fragment is dropped.
+type Canonicalizer struct {
+	// The StripParams are query parameters to remove from the URL (i.e. utm_source, fbclid). Names are matched
+	// case insensitively and a trailing '*' matches any parameter with that prefix.
+	StripParams []string
+
+	// SortQuery sorts the query parameters by name so that reordered queries are detected as duplicates.
+	SortQuery bool
+
+	// The LowercasePathHosts are the hosts that serve their paths case insensitively. The path of URLs on
+	// these hosts is lowercased.
+	LowercasePathHosts []string
+
+	// IgnoreScheme makes http and https URLs of the same page share the same duplicate key.
+	IgnoreScheme bool
+
+	// IgnoreWWW makes the "www." and bare hosts share the same duplicate key.
+	IgnoreWWW bool
+
+	// IgnoreTrailingSlash makes "/path" and "/path/" share the same duplicate key.
+	IgnoreTrailingSlash bool
+}
+
+// NewCanonicalizer returns a Canonicalizer with the default rules. These values can be overwritten
+// after initializing the new Canonicalizer reference.
+func NewCanonicalizer() *Canonicalizer {
+	return &Canonicalizer{
+		StripParams:         append([]string(nil), DefaultStripParams...),
+		SortQuery:           true,
+		IgnoreScheme:        true,
+		IgnoreWWW:           true,
+		IgnoreTrailingSlash: true,
+	}
+}
+
+// Canonicalize returns the canonical form of u. The returned URL is a copy, u is left untouched.
+// The canonical URL is still fetchable: the rules that could point to a different resource (scheme,
+// www and trailing slash) are only applied to the duplicate Key.
+func (c *Canonicalizer) Canonicalize(u *url.URL) *url.URL {
+	cu := *u
+	cu.Fragment = ""
+	cu.Scheme = strings.ToLower(cu.Scheme)
+	cu.Host = canonicalHost(cu.Scheme, cu.Host)
+
+	// normalize the percent-encoding of the path
+	escaped := c.stripPathParams(normalizeEscapes(cu.EscapedPath()))
+	if c.lowercasePath(cu.Host) {
+		escaped = strings.ToLower(escaped)
+	}
+	if escaped == "" && cu.Opaque == "" {
+		escaped = "/"
+	}
+	if p, err := url.PathUnescape(escaped); err == nil {
+		cu.Path, cu.RawPath = p, escaped
+	}
+
+	cu.RawQuery = c.canonicalQuery(cu.RawQuery)
+	cu.ForceQuery = false
+	return &cu
+}
+
+// Key returns the string used to detect duplicates of u. On top of Canonicalize it drops the
+// scheme, the "www." prefix and the trailing slash according to the Canonicalizer's settings.
+func (c *Canonicalizer) Key(u *url.URL) string {
+	cu := c.Canonicalize(u)
+	if c.IgnoreScheme {
+		cu.Scheme = ""
+	}
+	if c.IgnoreWWW {
+		cu.Host = strings.TrimPrefix(cu.Host, "www.")
+	}
+	if c.IgnoreTrailingSlash && len(cu.Path) > 1 {
+		cu.Path = strings.TrimSuffix(cu.Path, "/")
+		cu.RawPath = strings.TrimSuffix(cu.RawPath, "/")
+	}
+	return cu.String()
+}
+
+// lowercasePath returns true if the paths of host are case insensitive.
+func (c *Canonicalizer) lowercasePath(host string) bool {
+	for _, h := range c.LowercasePathHosts {
+		if strings.EqualFold(h, host) || strings.EqualFold(h, hostname(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripParam returns true if the query parameter name matches one of the StripParams.
+func (c *Canonicalizer) stripParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range c.StripParams {
+		p = strings.ToLower(p)
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// stripPathParams removes the stripped parameters that are set as path parameters of an escaped
+// path (i.e. "/page;jsessionid=1234").
+func (c *Canonicalizer) stripPathParams(escaped string) string {
+	if !strings.Contains(escaped, ";") {
+		return escaped
+	}
+
+	segments := strings.Split(escaped, "/")
+	for i, seg := range segments {
+		parts := strings.Split(seg, ";")
+		kept := parts[:1]
+		for _, p := range parts[1:] {
+			name := p
+			if j := strings.Index(p, "="); j >= 0 {
+				name = p[:j]
+			}
+			if !c.stripParam(name) {
+				kept = append(kept, p)
+			}
+		}
+		segments[i] = strings.Join(kept, ";")
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery removes the stripped parameters from a raw query, normalizes the percent-encoding
+// and sorts the parameters if SortQuery is set.
+func (c *Canonicalizer) canonicalQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	var params []string
+	for _, p := range strings.Split(raw, "&") {
+		if p == "" {
+			continue
+		}
+		name := p
+		if i := strings.Index(p, "="); i >= 0 {
+			name = p[:i]
+		}
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if c.stripParam(name) {
+			continue
+		}
+		params = append(params, normalizeEscapes(p))
+	}
+	if c.SortQuery {
+		sort.Strings(params)
+	}
+	return strings.Join(params, "&")
+}
+
+// canonicalHost lowercases the host, converts an internationalized host to punycode and removes
+// the default port of the scheme.
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	name, port := hostname(host), ""
+	if _, p, err := net.SplitHostPort(host); err == nil {
+		port = p
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	if ascii, err := idna.ToASCII(name); err == nil {
+		name = ascii
+	}
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	if strings.Contains(name, ":") {
+		// IPv6 literal
+		name = "[" + name + "]"
+	}
+	if port != "" {
+		return name + ":" + port
+	}
+	return name
+}
+
+// hostname returns the host without its port and IPv6 brackets.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// normalizeEscapes decodes the percent-encoded unreserved characters of s (letters, digits, '-',
+// '.', '_' and '~') and uppercases the hex digits of the remaining escapes.
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(b) {
+				buf.WriteByte(b)
+			} else {
+				buf.WriteByte('%')
+				buf.WriteString(strings.ToUpper(s[i+1 : i+3]))
+			}
+			i += 2
+			continue
+		}
+		buf.WriteByte(s[i])
+	}
+	return buf.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,144 @@
+package hermes
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	c := NewCanonicalizer()
+	for _, tc := range []struct {
+		in, want string
+	}{
+		// scheme, host, default ports, empty path and fragment
+		{"HTTP://Example.COM:80/a#frag", "http://example.com/a"},
+		{"https://example.com:443", "https://example.com/"},
+		{"https://example.com:80/", "https://example.com:80/"},
+		{"http://example.com:8080/", "http://example.com:8080/"},
+		{"http://example.com./a", "http://example.com/a"},
+		{"http://[::1]:80/", "http://[::1]/"},
+		{"http://bücher.example/", "http://xn--bcher-kva.example/"},
+		{"http://example.com/?", "http://example.com/"},
+
+		// the rules that could point to another resource are only applied to the Key
+		{"https://www.example.com/a/", "https://www.example.com/a/"},
+
+		// percent-encoding
+		{"http://example.com/%7euser/%2fa", "http://example.com/~user/%2Fa"},
+		{"http://example.com/?q=%7e%2f", "http://example.com/?q=~%2F"},
+
+		// query order and stripped params
+		{"http://example.com/p?b=2&a=1", "http://example.com/p?a=1&b=2"},
+		{"http://example.com/p?utm_source=x&b=2&fbclid=y&a=1", "http://example.com/p?a=1&b=2"},
+		{"http://example.com/p?UTM_Medium=x&q=1", "http://example.com/p?q=1"},
+		{"http://example.com/p?ASPSESSIONIDQQ=x&q=1", "http://example.com/p?q=1"},
+		{"http://example.com/p?utm%5Fsource=x&q=1", "http://example.com/p?q=1"},
+		{"http://example.com/p?gclid=1", "http://example.com/p"},
+		{"http://example.com/page;jsessionid=1234", "http://example.com/page"},
+		{"http://example.com/page;v=2;jsessionid=1234?q=1", "http://example.com/page;v=2?q=1"},
+
+		// content parameters are kept
+		{"http://example.com/thread?sid=5&id=7", "http://example.com/thread?id=7&sid=5"},
+	} {
+		u, err := url.Parse(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.Canonicalize(u).String(); got != tc.want {
+			t.Errorf("Canonicalize(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+		if u.String() != mustParse(t, tc.in).String() {
+			t.Errorf("Canonicalize(%q) modified its argument: %q", tc.in, u)
+		}
+	}
+}
+
+func TestCanonicalizerKey(t *testing.T) {
+	c := NewCanonicalizer()
+	for _, group := range [][]string{
+		{
+			"http://example.com/a",
+			"https://example.com/a",
+			"http://www.example.com/a",
+			"HTTPS://WWW.EXAMPLE.COM:443/a/",
+			"http://example.com/a#top",
+			"http://example.com/%61",
+		},
+		{
+			"http://example.com/search?q=go&page=2",
+			"http://example.com/search?page=2&q=go",
+			"http://example.com/search?page=2&utm_campaign=x&q=go",
+		},
+		{
+			"http://example.com",
+			"http://example.com/",
+			"https://www.example.com",
+		},
+	} {
+		want := c.Key(mustParse(t, group[0]))
+		for _, in := range group[1:] {
+			if got := c.Key(mustParse(t, in)); got != want {
+				t.Errorf("Key(%q) = %q, want %q like %q", in, got, want, group[0])
+			}
+		}
+	}
+
+	for _, pair := range [][2]string{
+		{"http://example.com/a", "http://example.com/b"},
+		{"http://example.com/a", "http://example.com/A"},
+		{"http://example.com/a", "http://example.com:8080/a"},
+		{"http://example.com/a", "http://sub.example.com/a"},
+		{"http://example.com/?q=1", "http://example.com/?q=2"},
+		{"http://example.com/?sid=1", "http://example.com/?sid=2"},
+	} {
+		if a, b := c.Key(mustParse(t, pair[0])), c.Key(mustParse(t, pair[1])); a == b {
+			t.Errorf("Key(%q) == Key(%q) == %q, want different keys", pair[0], pair[1], a)
+		}
+	}
+
+	if got, want := c.Key(mustParse(t, "https://www.example.com/a/?b=1&a=2")), "//example.com/a?a=2&b=1"; got != want {
+		t.Errorf("Key = %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalizerSettings(t *testing.T) {
+	// without the Ignore settings the scheme, www, trailing slash and query order matter
+	var c Canonicalizer
+	for _, pair := range [][2]string{
+		{"http://example.com/a", "https://example.com/a"},
+		{"http://example.com/a", "http://www.example.com/a"},
+		{"http://example.com/a", "http://example.com/a/"},
+		{"http://example.com/?a=1&b=2", "http://example.com/?b=2&a=1"},
+		{"http://example.com/?utm_source=x", "http://example.com/"},
+	} {
+		if a, b := c.Key(mustParse(t, pair[0])), c.Key(mustParse(t, pair[1])); a == b {
+			t.Errorf("Key(%q) == Key(%q) == %q, want different keys", pair[0], pair[1], a)
+		}
+	}
+
+	c.LowercasePathHosts = []string{"Example.com"}
+	if got, want := c.Canonicalize(mustParse(t, "http://example.com:8080/Docs/Page")).String(), "http://example.com:8080/docs/page"; got != want {
+		t.Errorf("Canonicalize = %q, want %q", got, want)
+	}
+	if got, want := c.Canonicalize(mustParse(t, "http://other.com/Docs")).String(), "http://other.com/Docs"; got != want {
+		t.Errorf("Canonicalize = %q, want %q", got, want)
+	}
+}
+
+func TestNewCanonicalizerCopiesStripParams(t *testing.T) {
+	first := DefaultStripParams[0]
+	c := NewCanonicalizer()
+	c.StripParams[0] = "changed"
+	if DefaultStripParams[0] != first {
+		t.Errorf("DefaultStripParams[0] = %q, want %q", DefaultStripParams[0], first)
+	}
+}
+
+func mustParse(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
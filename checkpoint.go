@@ -0,0 +1,278 @@
+package hermes
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrInterrupted defines a crawl that was shut down by a SIGINT or SIGTERM signal
+	ErrInterrupted = errors.New("crawl interrupted")
+	// ErrMissingCheckpointURL defines a checkpoint without a seed URL
+	ErrMissingCheckpointURL = errors.New("checkpoint has no seed URL")
+)
+
+type (
+	// A Checkpoint is the saved state of a crawl: the seed URL, the links still waiting in the
+	// queue (the frontier), the seen links and the documents scraped so far.
+	Checkpoint struct {
+		URL       string           `json:"url"`
+		Frontier  []CheckpointLink `json:"frontier"`
+		Seen      []byte           `json:"seen,omitempty"`
+		Documents []Document       `json:"documents,omitempty"`
+		Count     int              `json:"count"`
+		Time      time.Time        `json:"time"`
+	}
+
+	// A CheckpointLink is a link of the frontier with the method it was enqueued with and how
+	// it was discovered.
+	CheckpointLink struct {
+		URL    string `json:"url"`
+		Method string `json:"method"`
+		Depth  int    `json:"depth"`
+		Hops   int    `json:"hops"`
+
+		// The Sitemap holds the sitemap hints of the link, if it was found in a sitemap.
+		Sitemap *SitemapURL `json:"sitemap,omitempty"`
+	}
+)
+
+// LoadCheckpoint reads a Checkpoint saved by a Runner.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	if cp.URL == "" {
+		return nil, ErrMissingCheckpointURL
+	}
+	return &cp, nil
+}
+
+// Save writes the Checkpoint to path. The file is replaced atomically so that a crash while
+// saving never leaves a truncated checkpoint behind.
+func (cp *Checkpoint) Save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path, so that a crash while
+// writing never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Resume continues the crawl saved in the checkpoint at checkpointPath. The seen links and the
+// documents scraped before the interruption are restored and the frontier is enqueued instead of
+// the seed URL. If the Runner's URL is nil the checkpoint's seed URL is used.
+func (r *Runner) Resume(checkpointPath string) ([]Document, error) {
+	return r.ResumeContext(context.Background(), checkpointPath)
+}
+
+// ResumeContext is like Resume but it is bound to the given context, see CrawlContext.
+func (r *Runner) ResumeContext(ctx context.Context, checkpointPath string) ([]Document, error) {
+	cp, err := LoadCheckpoint(checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	if r.URL == nil {
+		u, err := url.Parse(cp.URL)
+		if err != nil {
+			return nil, err
+		}
+		r.URL = u
+	}
+	if r.CheckpointPath == "" {
+		r.CheckpointPath = checkpointPath
+	}
+	return r.crawl(ctx, cp)
+}
+
+// checkpoint takes a snapshot of the current crawl.
+func (r *Runner) checkpoint() (*Checkpoint, error) {
+	cp := &Checkpoint{URL: r.URL.String(), Time: time.Now()}
+
+	// stores that can't be marshaled (i.e. DiskSeenStore) persist themselves
+	if m, ok := r.seen.(encoding.BinaryMarshaler); ok {
+		seen, err := m.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		cp.Seen = seen
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for c := range r.pending {
+		cp.Frontier = append(cp.Frontier, CheckpointLink{
+			URL:     c.URL().String(),
+			Method:  c.Method(),
+			Depth:   c.depth,
+			Hops:    c.hops,
+			Sitemap: c.sitemap,
+		})
+	}
+	cp.Documents = append(cp.Documents, r.ingestionSet...)
+	cp.Count = r.count
+	return cp, nil
+}
+
+// saveCheckpoint saves a snapshot of the current crawl to the CheckpointPath.
+func (r *Runner) saveCheckpoint() {
+	cp, err := r.checkpoint()
+	if err == nil {
+		err = cp.Save(r.CheckpointPath)
+	}
+	if err != nil {
+		r.log().Error("checkpoint failed", Fields{"path": r.CheckpointPath, "error": err})
+	}
+}
+
+// restore sets the state of the Runner from a checkpoint before the crawl starts.
+func (r *Runner) restore(cp *Checkpoint) error {
+	if len(cp.Seen) > 0 {
+		if u, ok := r.seen.(encoding.BinaryUnmarshaler); ok {
+			if err := u.UnmarshalBinary(cp.Seen); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ingestionSet = append(r.ingestionSet, cp.Documents...)
+	r.count = cp.Count
+	return nil
+}
+
+// enqueueFrontier enqueues the links of a checkpoint's frontier. It returns the number of links
+// enqueued.
+func (r *Runner) enqueueFrontier(q *fetchbot.Queue, cp *Checkpoint) int {
+	n := 0
+	for _, l := range cp.Frontier {
+		u, err := url.Parse(l.URL)
+		if err != nil {
+			r.log().Error("resume failed", Fields{"method": l.Method, "url": l.URL, "error": err})
+			continue
+		}
+		if err := r.seen.Add(r.canonicalizer().Key(u)); err != nil {
+			r.log().Error("resume failed", Fields{"method": l.Method, "url": l.URL, "error": err})
+			continue
+		}
+		c := newLinkCmd(l.Method, u, l.Depth, l.Hops)
+		c.sitemap = l.Sitemap
+		if err := r.send(q, c); err != nil {
+			r.log().Error("resume failed", Fields{"method": l.Method, "url": l.URL, "error": err})
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// runCheckpoints saves a checkpoint every CheckpointInterval until done is closed.
+func (r *Runner) runCheckpoints(done <-chan struct{}) {
+	interval := r.CheckpointInterval * time.Second
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				r.saveCheckpoint()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// handleSignals cancels the queue on SIGINT or SIGTERM until done is closed. The interrupted
+// channel is closed when a signal was received.
+func (r *Runner) handleSignals(q *fetchbot.Queue, done <-chan struct{}) <-chan struct{} {
+	interrupted := make(chan struct{})
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigc)
+		select {
+		case <-sigc:
+			close(interrupted)
+			r.shutdown(q, r.done, true)
+		case <-done:
+		}
+	}()
+	return interrupted
+}
+
+// send enqueues the command and tracks it in the frontier until it is handled.
+func (r *Runner) send(q *fetchbot.Queue, c *linkCmd) error {
+	r.conditional(c)
+
+	r.mu.Lock()
+	r.pending[c] = struct{}{}
+	r.mu.Unlock()
+
+	err := q.Send(c)
+	if err != nil {
+		r.mu.Lock()
+		delete(r.pending, c)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// frontierHandler removes the commands from the frontier once they have been handled by the
+// wrapped Handler. Commands dropped by a cancelled queue are never handled, and commands dropped
+// once the MaximumDocuments is reached are marked as such, so both stay in the frontier to be
+// saved in the final checkpoint.
+func (r *Runner) frontierHandler(wrapped fetchbot.Handler) fetchbot.Handler {
+	return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		wrapped.Handle(ctx, res, err)
+		if c, ok := ctx.Cmd.(*linkCmd); ok && !c.dropped {
+			r.mu.Lock()
+			delete(r.pending, c)
+			r.mu.Unlock()
+		}
+	})
+}
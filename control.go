@@ -0,0 +1,292 @@
+package hermes
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrNotCrawling defines a control of a Runner that is not crawling
+	ErrNotCrawling = errors.New("runner is not crawling")
+	// ErrCancelled defines a request abandoned because the crawl was cancelled
+	ErrCancelled = errors.New("crawl cancelled")
+)
+
+// Progress is a snapshot of a running crawl.
+type Progress struct {
+	Crawling  bool      `json:"crawling"`
+	Paused    bool      `json:"paused"`
+	Start     time.Time `json:"start"`
+	Fetched   int       `json:"fetched"`
+	Documents int       `json:"documents"`
+	Queued    int       `json:"queued"`
+	Errors    int       `json:"errors"`
+	Hosts     int       `json:"hosts"`
+	Banned    []string  `json:"banned,omitempty"`
+}
+
+// Progress returns the progress of the crawl: the links fetched, the Documents scraped, the
+// links queued, the failures and the hosts the fetcher is crawling.
+func (r *Runner) Progress() Progress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := Progress{
+		Crawling:  r.queue != nil,
+		Paused:    r.resumed != nil,
+		Start:     r.started,
+		Fetched:   r.links,
+		Documents: r.count,
+		Queued:    len(r.pending),
+		Errors:    len(r.failed),
+	}
+	if r.debug != nil {
+		p.Hosts = r.debug.NumHosts
+	}
+	for host := range r.banned {
+		p.Banned = append(p.Banned, host)
+	}
+	sort.Strings(p.Banned)
+	return p
+}
+
+// Frontier returns the links queued that contain the query, at most limit if limit > 0, sorted
+// by URL.
+func (r *Runner) Frontier(query string, limit int) []CheckpointLink {
+	r.mu.Lock()
+	var links []CheckpointLink
+	for c := range r.pending {
+		u := c.URL().String()
+		if strings.Contains(u, query) {
+			links = append(links, CheckpointLink{URL: u, Method: c.Method(), Depth: c.depth, Hops: c.hops, Sitemap: c.sitemap})
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(links, func(i, j int) bool { return links[i].URL < links[j].URL })
+	if limit > 0 && len(links) > limit {
+		links = links[:limit]
+	}
+	return links
+}
+
+// AddURL adds a link to the frontier of the running crawl, even if it was seen already or is
+// out of scope.
+func (r *Runner) AddURL(u *url.URL) error {
+	r.mu.Lock()
+	q := r.queue
+	if q != nil {
+		delete(r.removed, r.canonicalizer().Key(u))
+	}
+	r.mu.Unlock()
+	if q == nil {
+		return ErrNotCrawling
+	}
+
+	if err := r.seen.Add(r.canonicalizer().Key(u)); err != nil {
+		return err
+	}
+	return r.send(q, newLinkCmd("GET", u, 0, 0))
+}
+
+// RemoveURL removes a link from the frontier of the running crawl. It returns false if the link
+// is not in the frontier.
+func (r *Runner) RemoveURL(u *url.URL) bool {
+	key := r.canonicalizer().Key(u)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue == nil {
+		return false
+	}
+	removed := false
+	for c := range r.pending {
+		if r.canonicalizer().Key(c.URL()) == key {
+			delete(r.pending, c)
+			removed = true
+		}
+	}
+	if removed {
+		r.removed[key] = true
+	}
+	return removed
+}
+
+// BanHost stops the running crawl from fetching the pages of a host: the links of the host are
+// removed from the frontier and the links found later are ignored. It returns the number of
+// links removed from the frontier.
+func (r *Runner) BanHost(host string) (int, error) {
+	host = strings.ToLower(host)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue == nil {
+		return 0, ErrNotCrawling
+	}
+	r.banned[host] = true
+	n := 0
+	for c := range r.pending {
+		if strings.ToLower(c.URL().Host) == host {
+			delete(r.pending, c)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Pause holds the requests of the running crawl until Unpause is called. The requests already
+// sent are still handled.
+func (r *Runner) Pause() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue == nil {
+		return ErrNotCrawling
+	}
+	if r.resumed == nil {
+		r.resumed = make(chan struct{})
+	}
+	return nil
+}
+
+// Unpause resumes a paused crawl. Not to be confused with Resume, which continues a crawl from
+// a Checkpoint.
+func (r *Runner) Unpause() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue == nil {
+		return ErrNotCrawling
+	}
+	r.resume()
+	return nil
+}
+
+// Stop closes the queue of the running crawl: the links already queued are still processed,
+// like when the StopDuration is reached.
+func (r *Runner) Stop() error {
+	return r.stop(false)
+}
+
+// Cancel cancels the queue of the running crawl: the links queued are dropped, like when the
+// CancelDuration is reached.
+func (r *Runner) Cancel() error {
+	return r.stop(true)
+}
+
+// stop closes or cancels the queue of the running crawl.
+func (r *Runner) stop(cancel bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q := r.queue
+	if q == nil {
+		return ErrNotCrawling
+	}
+	// closing the queue blocks until it is drained
+	go r.shutdown(q, r.done, cancel)
+	return nil
+}
+
+// shutdown resumes a paused crawl, so that its queue can be drained, then closes or cancels the
+// queue. Cancelling also closes done, the channel of the crawl of the queue, which ends the waits
+// of the throttle and of the retries. It blocks until the queue is drained.
+func (r *Runner) shutdown(q *fetchbot.Queue, done chan struct{}, cancel bool) {
+	r.mu.Lock()
+	r.resume()
+	if cancel {
+		select {
+		case <-done:
+		default:
+			close(done)
+		}
+	}
+	r.mu.Unlock()
+	if cancel {
+		_ = q.Cancel()
+	} else {
+		_ = q.Close()
+	}
+}
+
+// resume releases the requests held by Pause. The lock must be held.
+func (r *Runner) resume() {
+	if r.resumed != nil {
+		close(r.resumed)
+		r.resumed = nil
+	}
+}
+
+// control sets the queue of the running crawl, nil once it is done. The state of the controls is
+// reset.
+func (r *Runner) control(q *fetchbot.Queue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queue = q
+	r.resume()
+	r.banned = make(map[string]bool)
+	r.removed = make(map[string]bool)
+}
+
+// skipped returns true if the link was removed from the frontier or its host was banned.
+func (r *Runner) skipped(u *url.URL) bool {
+	key := r.canonicalizer().Key(u)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.banned[strings.ToLower(u.Host)] || r.removed[key]
+}
+
+// watchDebug keeps the latest debug info of the fetcher until done is closed.
+func (r *Runner) watchDebug(f *fetchbot.Fetcher, done <-chan struct{}) {
+	c := f.Debug()
+	go func() {
+		for {
+			select {
+			case di := <-c:
+				r.mu.Lock()
+				r.debug = di
+				r.mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// debugInfo returns the latest debug info of the fetcher, nil if there is none yet.
+func (r *Runner) debugInfo() *fetchbot.DebugInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.debug
+}
+
+// controlDoer is a fetchbot Doer that holds the requests of a paused Runner, and skips the
+// requests of the links removed from the frontier and of the banned hosts. The requests are
+// bound to the context of the crawl, so that cancelling the crawl also ends the requests in
+// flight, from their dial on.
+type controlDoer struct {
+	r    *Runner
+	ctx  context.Context
+	doer fetchbot.Doer
+}
+
+// Do waits for a paused crawl to resume and does the request, unless it is skipped.
+func (d *controlDoer) Do(req *http.Request) (*http.Response, error) {
+	d.r.mu.Lock()
+	resumed := d.r.resumed
+	d.r.mu.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+
+	if d.r.skipped(req.URL) {
+		return nil, ErrSkipped
+	}
+	res, err := d.doer.Do(req.WithContext(d.ctx))
+	if err != nil && d.ctx.Err() != nil {
+		// the crawl was cancelled while the request was in flight
+		return nil, ErrCancelled
+	}
+	return res, err
+}
@@ -0,0 +1,125 @@
+package hermes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// A CookieJar is an http.CookieJar that can be saved to a file and loaded back, so that the
+// cookies (i.e. a session) survive across crawls. It follows the same rules as the standard
+// library's cookiejar, with the Public Suffix List.
+type CookieJar struct {
+	path string
+	jar  *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]jarCookie
+}
+
+// jarCookie is a cookie saved by a CookieJar with the URL that set it.
+type jarCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+// OpenCookieJar returns a CookieJar saved to the file at path, loading the cookies already in it.
+// The file doesn't have to exist. An empty path returns a CookieJar that is only kept in memory.
+func OpenCookieJar(path string) (*CookieJar, error) {
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	j := &CookieJar{path: path, jar: jar, cookies: make(map[string]jarCookie)}
+	if path == "" {
+		return j, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []jarCookie
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, c := range saved {
+		u, err := url.Parse(c.URL)
+		if err != nil || c.Cookie == nil || (!c.Cookie.Expires.IsZero() && c.Cookie.Expires.Before(now)) {
+			continue
+		}
+		j.SetCookies(u, []*http.Cookie{c.Cookie})
+	}
+	return j, nil
+}
+
+// SetCookies implements the http.CookieJar interface.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		key := cookieKey(u, c)
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(time.Now())) {
+			delete(j.cookies, key)
+			continue
+		}
+		saved := *c
+		if c.MaxAge > 0 {
+			// a Max-Age is relative to now, it is saved as an expiry date
+			saved.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+			saved.MaxAge = 0
+		}
+		j.cookies[key] = jarCookie{URL: (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String(), Cookie: &saved}
+	}
+}
+
+// cookieKey returns the cookiejar's identity of a cookie: its domain, path and name. The domain
+// of a host-only cookie is the host that set it, the one of a domain cookie is its Domain
+// attribute, whichever host of the domain set it.
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	if domain == "" {
+		domain = strings.ToLower(u.Hostname())
+	}
+	return domain + ";" + c.Path + ";" + c.Name
+}
+
+// Cookies implements the http.CookieJar interface.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Save writes the cookies of the jar to its file. Session cookies are saved too, since a crawl
+// is a single session. The file is replaced atomically and only readable by its owner. Save does
+// nothing for a CookieJar without a file.
+func (j *CookieJar) Save() error {
+	if j.path == "" {
+		return nil
+	}
+	j.mu.Lock()
+	saved := make([]jarCookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		saved = append(saved, c)
+	}
+	j.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(j.path, data)
+}
@@ -2,7 +2,6 @@ package hermes
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
 	"net/url"
 	"runtime"
@@ -12,12 +11,22 @@ import (
 
 	"github.com/PuerkitoBio/fetchbot"
 	"github.com/PuerkitoBio/goquery"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
 )
 
 const (
 	DefaultUserAgent = "Hermes Bot (github.com/jtaylor32/hermes"
 )
 
+var (
+	// ErrUnsupportedScheme defines a link that is not an http or https URL (mailto:, javascript:, tel:, etc.)
+	ErrUnsupportedScheme = errors.New("unsupported link scheme")
+	// ErrEmptyHost defines a link that resolves to a URL without a host
+	ErrEmptyHost = errors.New("link has an empty host")
+)
+
 // A Runner defines the parameters for running a single instance of Hermes ETL
 type Runner struct {
 	// The CrawlDelay is the set time for the Runner to abide by.
@@ -48,147 +57,389 @@ type Runner struct {
 	// AutoClose will make the Runner terminate and successfully exit after the WorkerIdleTTL if set to true.
 	AutoClose bool
 
+	// StopOnDone controls what happens to the queue when the context given to CrawlContext is done. If it is set
+	// to true the queue is closed and the remaining links in the queue are still processed, otherwise the queue is
+	// cancelled immediately.
+	StopOnDone bool
+
 	// The URL a reference pointer to a URL type
 	URL *url.URL
 
 	// The Tags are the HTML tags you want to scrape with this Runner
 	Tags []string
 
+	// The Canonicalizer turns links into their canonical form to detect duplicates and to set the Document's Link.
+	// If it is nil the rules of NewCanonicalizer are used.
+	Canonicalizer *Canonicalizer
+
+	// The Scope holds the include/exclude rules, path prefixes, maximum depth and external hops a link must pass
+	// to be crawled, on top of the TopLevelDomain and Subdomain toggles. If it is nil only the toggles apply.
+	Scope *Scope
+
+	// The SeenStore keeps track of the links already enqueued. If it is nil a new MemorySeenStore is used for every
+	// crawl. Use a BloomSeenStore for multi-million link crawls or a DiskSeenStore to share it across runs.
+	SeenStore SeenStore
+
+	// The CheckpointPath is the file the Runner periodically saves its frontier, seen links and scraped documents to,
+	// so that an interrupted crawl can be continued with Resume. Leave it empty to disable checkpointing.
+	CheckpointPath string
+
+	// The CheckpointInterval is the set time (in seconds) between two checkpoints. A final checkpoint is always saved
+	// when the crawl ends.
+	CheckpointInterval time.Duration
+
+	// HandleSignals makes the Runner shut down gracefully on SIGINT/SIGTERM: the queue is cancelled, a final checkpoint
+	// is saved and the documents scraped so far are returned with ErrInterrupted.
+	HandleSignals bool
+
+	// Sitemaps makes the Runner discover the sitemaps of the URL's host (from the robots.txt "Sitemap:" lines and
+	// /sitemap.xml) and seed the crawl with their URLs, so that poorly linked pages still get crawled. It is off by
+	// default: it fetches the robots.txt of the host once more, and /sitemap.xml.
+	Sitemaps bool
+
+	// IgnoreRobotsDirectives makes the Runner ignore the page-level robots directives (noindex, nofollow, noarchive,
+	// unavailable_after) of meta tags and X-Robots-Tag headers, and the rel="nofollow" links. Only use it for internal
+	// audits of your own sites.
+	IgnoreRobotsDirectives bool
+
+	// DisablePoliteness disables fetching and using the robots.txt policies of the hosts.
+	DisablePoliteness bool
+
+	// The PageStore remembers the ETag, Last-Modified, content hash and links of the pages crawled. If it is set the
+	// pages crawled before are requested with If-None-Match/If-Modified-Since headers, and a page that is not modified
+	// is not scraped again: its Document is marked as Unchanged. Use OpenPageStore to keep it across crawls.
+	PageStore PageStore
+
+	// The HTTP defines the timeouts, proxy, TLS certificates, headers and cookie jar of the HTTP client used for every
+	// request of the Runner. If it is nil the net/http default client is used, which has no timeout.
+	HTTP *HTTPConfig
+
+	// The Auth logs the Runner in before the crawl, with a login form or a replayed request, and logs it in again
+	// when the session expires. It also holds the basic or bearer credentials sent to the hosts.
+	Auth *Auth
+
+	// The Throttle rate limits the requests per host, backing off on 429/503 responses and rising latency and honoring
+	// Retry-After headers. The CrawlDelay is the base delay of every host, unless it is overridden in the Throttle's
+	// HostDelays. If it is nil the CrawlDelay is applied as a fixed delay to every host.
+	Throttle *Throttle
+
+	// The RetryPolicy retries the links that fail with a transient error or status code (5xx, 429) with an exponential
+	// backoff. If it is nil (the default) failed links are not retried, set it to NewRetryPolicy() to enable the
+	// retries. The links that fail permanently are available from Failed.
+	RetryPolicy *RetryPolicy
+
+	// The FailureThreshold is the fraction (0 to 1) of the links that can fail before the crawl is considered broken:
+	// if more links fail, Crawl returns ErrTooManyFailures along with the Documents. A seed that can't be fetched
+	// always breaks the crawl, Crawl returns ErrSeedFailed. It is 0 by default, which never fails the crawl for
+	// failed links other than the seed. See Result for the failures.
+	FailureThreshold float64
+
 	// If you want to specify how many documents you want to crawl/scrape the Runner will hit you can specify the size here.
 	// If you don't have a specific preference you can leave it alone or set it to 0.
 	MaximumDocuments int
 
-	// The TopLevelDomain is a toggle to determine if you want to limit the Runner to a specific TLD. (i.e. .com, .edu, .gov, etc.)
-	// If it is set to true it will make sure it stays to the URL's specific TLD.
+	// The TopLevelDomain is a toggle to determine if you want to limit the Runner to a specific TLD. (i.e. .com, .edu, .co.uk, etc.)
+	// If it is set to true (and Subdomain is false) it will make sure it stays to the URL's specific TLD. TLDs come from the
+	// Public Suffix List.
 	TopLevelDomain bool
 
 	// The Subdomain is a toggle to determine if you want to limit the Runner to a subdomain of the URL. If it is set to true
-	// it will make sure it stays to the host's domain. Think of it like a wildcard -- *.github.com -- anything link that has
-	// github.com will be fetched.
+	// it will make sure it stays to the host's registrable domain. Think of it like a wildcard -- *.github.com -- anything link that has
+	// github.com will be fetched, while bbc.co.uk and foo.co.uk are different domains. If neither toggle is set the Runner stays
+	// on the URL's host.
 	Subdomain bool
 
+	// The DocumentHandler receives every Document as soon as it is scraped. If it is set the Documents are streamed
+	// to it instead of being collected and returned by Crawl, which keeps memory flat on large sites.
+	DocumentHandler DocumentHandler
+
+	// The Hooks are called at the stages of the crawl: before every request, with every response, link, Document
+	// and failure. They can change the requests, links and Documents, or skip them.
+	Hooks Hooks
+
+	// The Middleware wraps the handler of the responses, the first one is the outermost. See Use.
+	Middleware []Middleware
+
+	// The Logger receives the leveled log entries of the Runner, with the url, method, status, host and error of the
+	// request as fields. If it is nil nothing is logged, use NewLogrusLogger to log with logrus.
+	Logger Logger
+
+	// The Metrics collects the pages fetched, bytes downloaded, latencies, frontier size, duplicate links and failures
+	// of the crawl, to expose them in the Prometheus text format. It can be shared with other Runners.
+	Metrics *Metrics
+
+	// The TracerProvider traces the crawl with OpenTelemetry spans: the fetches and robots.txt requests, with their
+	// url, host, status and bytes, and the parsing, link extraction and scraping of the pages. If it is nil nothing
+	// is traced.
+	TracerProvider trace.TracerProvider
+
+	// The ReportPath is where the Report of every crawl is written at its end, as ReportPath.json and ReportPath.html.
+	// If it is empty no report is written, it is still available from Report.
+	ReportPath string
+
 	// the ingestionSet is the array of documents that is scraped by the scraper to be sent back for storage.
 	ingestionSet []Document
-
-	// Protect access to dup
+	// count is the number of documents scraped so far
+	count int
+	// handlerErr is the first error returned by the DocumentHandler
+	handlerErr error
+	// Serialize the calls to the DocumentHandler
+	hmu sync.Mutex
+
+	// Protect access to ingestionSet, count, handlerErr, pending, disallowed, failed and the controls
 	mu sync.Mutex
-	// Duplicates table
-	dup map[string]bool
+	// Protect the check-then-add sequence on seen
+	smu sync.Mutex
+	// Sitemaps already enqueued
+	sitemaps map[string]bool
+	// URLs disallowed by robots.txt
+	disallowed []string
+	// Links that failed permanently
+	failed []Failure
+	// Number of links fetched, a link probed with HEAD then fetched with GET counts once
+	links int
+	// Outcome of the last crawl
+	result *CrawlResult
+	// Report of the last crawl
+	report *Report
+	// Statistics of the current crawl for its report
+	stats *crawlStats
+	// Start of the current crawl
+	started time.Time
+	// Queue of the running crawl, nil when it is not crawling
+	queue *fetchbot.Queue
+	// Latest debug info of the fetcher
+	debug *fetchbot.DebugInfo
+	// Closed when the crawl is cancelled, to end the waits of the throttle and of the retries
+	done chan struct{}
+	// Closed when a paused crawl resumes, nil if it is not paused
+	resumed chan struct{}
+	// Hosts banned during the crawl
+	banned map[string]bool
+	// Links removed from the frontier during the crawl, by canonical key
+	removed map[string]bool
+	// Duplicates table of the current crawl
+	seen SeenStore
+	// Commands enqueued but not handled yet (the frontier)
+	pending map[*linkCmd]struct{}
+	// URLs fetched instead of the URL, without following their links (see Revisitor)
+	seeds []*url.URL
+	// Serialize the logins
+	amu sync.Mutex
+	// Number of logins since the crawl started, updated atomically
+	logins int64
 }
 
 // New returns a default Runner type. These values can be overwritten to whatever
 // after initializing the new Runner reference.
 func New() *Runner {
 	return &Runner{
-		CrawlDelay:       1,
-		CancelDuration:   60,
-		CancelAtURL:      "",
-		StopDuration:     60,
-		StopAtURL:        "",
-		MemStatsInterval: 0,
-		UserAgent:        DefaultUserAgent,
-		WorkerIdleTTL:    10,
-		AutoClose:        true,
-		MaximumDocuments: 100,
-		TopLevelDomain:   true,
-		Subdomain:        true,
+		CrawlDelay:         1,
+		CancelDuration:     60,
+		CancelAtURL:        "",
+		StopDuration:       60,
+		StopAtURL:          "",
+		MemStatsInterval:   0,
+		UserAgent:          DefaultUserAgent,
+		WorkerIdleTTL:      10,
+		AutoClose:          true,
+		MaximumDocuments:   100,
+		TopLevelDomain:     true,
+		Subdomain:          true,
+		Canonicalizer:      NewCanonicalizer(),
+		CheckpointInterval: 60,
+		HTTP:               NewHTTPConfig(),
+		Throttle:           NewThrottle(),
 	}
 }
 
-// func init() {
-// 	// Log as JSON instead of the default ASCII formatter.
-// 	log.SetFormatter(&log.JSONFormatter{})
-
-// 	// File to output logs to
-// 	now := time.Now()
-// 	pre := now.Format("2006-01-02")
-// 	filename := "./" + pre + "-log.log"
-// 	f, err := os.OpenFile(
-// 		filename,
-// 		os.O_CREATE|os.O_RDWR|os.O_APPEND,
-// 		0755,
-// 	)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	// Output to filename
-// 	log.SetOutput(f)
-
-// 	// Output to stdout instead of the default stderr
-// 	// log.SetOutput(os.Stdout)
-
-// 	// Only log the warning severity or above.
-// 	log.SetLevel(log.InfoLevel)
-// }
-
 // Crawl function that will take a url string and start firing out some crawling functions
 // it will return true/false based on the url root it starts with.
 func (r *Runner) Crawl() ([]Document, error) {
-	r.dup = make(map[string]bool)
+	return r.CrawlContext(context.Background())
+}
+
+// CrawlContext is like Crawl but it is bound to the given context. When the context is cancelled
+// or hits its deadline the queue is cancelled (or closed if StopOnDone is set) and the documents
+// scraped so far are returned along with the context's error.
+func (r *Runner) CrawlContext(ctx context.Context) ([]Document, error) {
+	return r.crawl(ctx, nil)
+}
+
+// crawl runs the crawl, starting from the seed URL or from the frontier of the checkpoint if
+// it is not nil.
+func (r *Runner) crawl(ctx context.Context, cp *Checkpoint) ([]Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.seen = r.SeenStore
+	if r.seen == nil {
+		r.seen = NewMemorySeenStore()
+	}
+	r.ingestionSet = nil
+	r.count = 0
+	r.handlerErr = nil
+	r.pending = make(map[*linkCmd]struct{})
+	r.sitemaps = make(map[string]bool)
+	r.disallowed = nil
+	r.failed = nil
+	r.links = 0
+	r.debug = nil
+	r.stats = newCrawlStats()
+	done := make(chan struct{})
+	r.done = done
+	r.started = time.Now()
 
 	if r.MaximumDocuments < 0 {
 		return r.ingestionSet, errors.New("you cannot have a negative document size")
 	}
 
+	if cp != nil {
+		if err := r.restore(cp); err != nil {
+			return nil, err
+		}
+	}
+
+	doer, err := r.httpClient()
+	if err != nil {
+		return r.ingestionSet, err
+	}
+
+	// Log in before the crawl starts, the session cookies go to the cookie jar
+	if r.Auth != nil && r.Auth.hasLogin() {
+		if err := r.login(doer); err != nil {
+			return r.ingestionSet, err
+		}
+	}
+
+	// expose the frontier while crawling
+	r.Metrics.track(r, true)
+	defer r.Metrics.track(r, false)
+
 	// Create the muxer
 	mux := fetchbot.NewMux()
 
 	// Handle all errors the same
 	mux.HandleErrors(fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
-		fmt.Printf("[ERR] %s %s - %s\n", ctx.Cmd.Method(), ctx.Cmd.URL(), err)
+		r.log().Error("request failed", cmdFields(ctx.Cmd, 0, err))
 	}))
 
-	// Handle GET requests for html responses, to parse the body and enqueue all links as HEAD
-	// requests.
-	mux.Response().Method("GET").ContentType("text/html").Handler(fetchbot.HandlerFunc(
-		func(ctx *fetchbot.Context, res *http.Response, err error) {
-			// Process the body to find the links
-			doc, err := goquery.NewDocumentFromReader(res.Body)
-			if err != nil {
-				return
-			}
-			// Enqueue all links as HEAD requests
-			r.enqueueLinks(ctx, doc)
-		}))
+	// The requests skipped by the OnRequest hook are not errors
+	mux.HandleError(ErrSkipped, fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		r.log().Debug("request skipped", cmdFields(ctx.Cmd, 0, nil))
+	}))
+
+	// The requests abandoned by a cancelled crawl stay in the frontier, like the links dropped by the queue
+	mux.HandleError(ErrCancelled, fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		if c, ok := ctx.Cmd.(*linkCmd); ok {
+			c.dropped = true
+		}
+		r.log().Debug("request cancelled", cmdFields(ctx.Cmd, 0, nil))
+	}))
+
+	// Record the URLs disallowed by robots.txt
+	mux.HandleError(fetchbot.ErrDisallowed, fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		r.mu.Lock()
+		r.disallowed = append(r.disallowed, ctx.Cmd.URL().String())
+		r.mu.Unlock()
+	}))
+
+	// Handle successful GET requests for html responses, to parse the body a single time, scrape
+	// the document and enqueue all links as HEAD requests.
+	mux.Response().Method("GET").Status(http.StatusOK).ContentType("text/html").Handler(r.pageHandler())
 
-	// Handle HEAD requests for html responses coming from the source host - we don't want
-	// to crawl links from other hosts.
-	mux.Response().Method("HEAD").Host(r.URL.Host).ContentType("text/html").Handler(fetchbot.HandlerFunc(
+	// Handle the pages that were not modified since the last crawl
+	if r.PageStore != nil {
+		mux.Response().Method("GET").Status(http.StatusNotModified).Handler(r.notModifiedHandler())
+	}
+
+	// Handle HEAD requests for html responses that are in the Runner's scope - we don't want
+	// to crawl links from other hosts. The scope is checked again in case of a redirect.
+	mux.Response().Method("HEAD").ContentType("text/html").Handler(fetchbot.HandlerFunc(
 		func(ctx *fetchbot.Context, res *http.Response, err error) {
-			if _, err := ctx.Q.SendStringGet(ctx.Cmd.URL().String()); err != nil {
-				fmt.Printf("[ERR] %s %s - %s\n", ctx.Cmd.Method(), ctx.Cmd.URL(), err)
+			u := ctx.Cmd.URL()
+			if res.Request != nil && res.Request.URL != nil {
+				u = res.Request.URL
+			}
+			depth, hops := linkInfo(ctx.Cmd)
+			if u.String() != ctx.Cmd.URL().String() {
+				var err error
+				if hops, err = r.checkScope(u, depth, hops); err != nil {
+					r.stats.observeOutOfScope(err)
+					r.log().Debug("out of scope", requestFields("", u, 0, err))
+					return
+				}
+			}
+			next := newLinkCmd("GET", ctx.Cmd.URL(), depth, hops)
+			next.probed = true
+			if c, ok := ctx.Cmd.(*linkCmd); ok {
+				next.sitemap = c.sitemap
+			}
+			if err := r.send(ctx.Q, next); err != nil {
+				r.log().Error("enqueue failed", requestFields("GET", ctx.Cmd.URL(), 0, err))
 			}
 		}))
 
 	// Create the Fetcher, handle the logging first, then dispatch to the Muxer
-	h := r.scrapeHandler(r.MaximumDocuments, mux)
+	page := r.retryHandler(r.middleware(mux))
+	if r.Auth != nil && r.Auth.hasLogin() {
+		page = r.authHandler(doer, page)
+	}
+	h := r.scrapeHandler(r.MaximumDocuments, page)
 
 	if r.StopAtURL != "" || r.CancelAtURL != "" {
 		stopURL := r.StopAtURL
 		if r.CancelAtURL != "" {
 			stopURL = r.CancelAtURL
 		}
-		h = stopHandler(stopURL, r.CancelAtURL != "", r.scrapeHandler(r.MaximumDocuments, mux))
+		h = r.stopHandler(stopURL, r.CancelAtURL != "", h)
 	}
-	f := fetchbot.New(h)
+	f := fetchbot.New(r.frontierHandler(h))
 
 	// set the fetchbots settings from flag parameters
 	f.UserAgent = r.UserAgent
 	f.CrawlDelay = r.CrawlDelay * time.Second
 	f.WorkerIdleTTL = r.WorkerIdleTTL * time.Second
 	f.AutoClose = r.AutoClose
+	f.DisablePoliteness = r.DisablePoliteness
+
+	// every request goes through the client of the HTTPConfig, with its headers and credentials
+	f.HttpClient = &statsDoer{r: r, doer: doer}
+	if r.TracerProvider != nil {
+		f.HttpClient = &traceDoer{r: r, doer: f.HttpClient}
+	}
+
+	// the Throttle takes over the crawl delay of every host
+	if r.Throttle != nil {
+		f.HttpClient = &throttledDoer{t: r.Throttle, base: f.CrawlDelay, done: done, doer: f.HttpClient}
+		f.CrawlDelay = 0
+	}
+	if r.Hooks.OnRequest != nil {
+		f.HttpClient = &hookDoer{r: r, doer: f.HttpClient}
+	}
+	// the requests in flight are cancelled along with the crawl
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	go func() {
+		select {
+		case <-done:
+			cancelRequests()
+		case <-requests.Done():
+		}
+	}()
+	f.HttpClient = &controlDoer{r: r, ctx: requests, doer: f.HttpClient}
 
 	// First mem stat print must be right after creating the fetchbot
 	if r.MemStatsInterval > 0 {
 		// Print starting stats
-		printMemStats(nil)
+		printMemStats(nil, r.log())
 		// Run at regular intervals
-		runMemStats(f, r.MemStatsInterval)
+		runMemStats(r.debugInfo, r.MemStatsInterval, r.log())
 		// On exit, print ending stats after a GC
 		defer func() {
 			runtime.GC()
-			printMemStats(nil)
+			printMemStats(nil, r.log())
 		}()
 	}
 
@@ -196,230 +447,391 @@ func (r *Runner) Crawl() ([]Document, error) {
 	q := f.Start()
 
 	// if a stop or cancel is requested after some duration, launch the goroutine
-	// that will stop or cancel.
+	// that will stop or cancel, unless the crawl is finished first.
+	finished := make(chan struct{})
+	defer close(finished)
 	if r.StopDuration*time.Minute > 0 || r.CancelDuration*time.Minute > 0 {
 		after := r.StopDuration * time.Minute
-		stopFunc := q.Close
+		cancel := false
 		if r.CancelDuration != 0 {
 			after = r.CancelDuration * time.Minute
-			stopFunc = q.Cancel
+			cancel = true
 		}
 
 		go func() {
-			c := time.After(after)
-			<-c
-			stopFunc()
+			t := time.NewTimer(after)
+			defer t.Stop()
+			select {
+			case <-t.C:
+				r.shutdown(q, done, cancel)
+			case <-finished:
+			}
 		}()
 	}
 
-	// Enqueue the seed, which is the first entry in the dup map
-	r.dup[r.URL.String()] = true
-	_, err := q.SendStringGet(r.URL.String())
-	if err != nil {
-		fmt.Printf("[ERR] GET %s - %s\n", r.URL.String(), err)
+	// stop or cancel the queue as soon as the context is done
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.shutdown(q, done, !r.StopOnDone)
+		case <-finished:
+		}
+	}()
+
+	// keep the debug info of the fetcher and let the queue be controlled while crawling
+	r.watchDebug(f, finished)
+	r.control(q)
+	defer r.control(nil)
+
+	// shut down gracefully on SIGINT/SIGTERM
+	var interrupted <-chan struct{}
+	if r.HandleSignals {
+		interrupted = r.handleSignals(q, finished)
+	}
+
+	// save checkpoints at regular intervals
+	if r.CheckpointPath != "" {
+		r.runCheckpoints(finished)
+	}
+
+	enqueued := 0
+	if cp != nil {
+		// Enqueue the frontier of the interrupted crawl
+		enqueued = r.enqueueFrontier(q, cp)
+	} else if r.seeds != nil {
+		// Enqueue the pages to revisit
+		for _, u := range r.seeds {
+			err := r.seen.Add(r.canonicalizer().Key(u))
+			if err == nil {
+				err = r.send(q, newLinkCmd("GET", u, 0, 0))
+			}
+			if err != nil {
+				r.log().Error("enqueue failed", requestFields("GET", u, 0, err))
+				continue
+			}
+			enqueued++
+		}
+	} else {
+		// Enqueue the seed, which is the first entry in the seen store
+		err := r.seen.Add(r.canonicalizer().Key(r.URL))
+		if err == nil {
+			err = r.send(q, newLinkCmd("GET", r.URL, 0, 0))
+		}
+		if err != nil {
+			r.log().Error("enqueue failed", requestFields("GET", r.URL, 0, err))
+		} else {
+			enqueued++
+		}
+
+		// Seed the frontier with the URLs of the sitemaps too
+		if r.Sitemaps {
+			enqueued += r.discoverSitemaps(q)
+		}
+	}
+	if enqueued == 0 {
+		// nothing to crawl, the queue would never close by itself
+		_ = q.Close()
 	}
 	q.Block()
 
+	// the final checkpoint keeps the links the queue didn't get to
+	if r.CheckpointPath != "" {
+		r.saveCheckpoint()
+	}
+
+	// keep the cookies and the pages for the next crawl
+	if r.HTTP != nil {
+		r.save("cookies", r.HTTP.CookieJar)
+	}
+	r.save("page store", r.PageStore)
+
+	r.mu.Lock()
+	r.result = r.newResult(r.started)
+	r.report = r.newReport(r.result)
+	r.mu.Unlock()
+	if r.ReportPath != "" {
+		r.saveReport()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlerErr != nil {
+		return r.ingestionSet, r.handlerErr
+	}
+	select {
+	case <-interrupted:
+		return r.ingestionSet, ErrInterrupted
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return r.ingestionSet, err
+	}
+	if r.seedFailed() {
+		return r.ingestionSet, ErrSeedFailed
+	}
+	if r.FailureThreshold > 0 && r.result.FailureRate() > r.FailureThreshold {
+		return r.ingestionSet, ErrTooManyFailures
+	}
 	return r.ingestionSet, nil
 }
 
 // stopHandler stops the fetcher if the stopurl is reached. Otherwise it dispatches
 // the call to the wrapped Handler.
-func stopHandler(stopurl string, cancel bool, wrapped fetchbot.Handler) fetchbot.Handler {
+func (r *Runner) stopHandler(stopurl string, cancel bool, wrapped fetchbot.Handler) fetchbot.Handler {
 	return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
 		if ctx.Cmd.URL().String() == stopurl {
 			// generally not a good idea to stop/block from a handler goroutine
 			// so do it in a separate goroutine
-			go func() {
-				if cancel {
-					_ = ctx.Q.Cancel()
-				} else {
-					_ = ctx.Q.Close()
-				}
-			}()
+			go r.shutdown(ctx.Q, r.done, cancel)
 			return
 		}
 		wrapped.Handle(ctx, res, err)
 	})
 }
 
-// scrapeHandler will fire a scraper function on the page if successful response,
-// append the scraped document stored for index ingestion
-// and dispatches the call to the wrapped Handler.
+// scrapeHandler will cancel the crawl once the maximum number of documents has been
+// scraped, print the response status and dispatches the call to the wrapped Handler.
 func (r *Runner) scrapeHandler(n int, wrapped fetchbot.Handler) fetchbot.Handler {
 	return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
-		if err == nil && len(r.ingestionSet) < n {
-			if res.StatusCode == 200 {
-				responseDocument, err := scrape(ctx, r.Tags)
-				if err != nil {
-					fmt.Printf("[ERR] scraping: %v", err)
-				}
-
-				r.mu.Lock()
-				defer r.mu.Unlock()
-				r.ingestionSet = append(r.ingestionSet, responseDocument)
+		if r.limitReached(n) {
+			if c, ok := ctx.Cmd.(*linkCmd); ok {
+				c.dropped = true
 			}
-			fmt.Printf("[%d] %s %s - %s\n", res.StatusCode, ctx.Cmd.Method(), ctx.Cmd.URL(), res.Header.Get("Content-Type"))
-		} else if len(r.ingestionSet) >= n {
-			go func() {
-				ctx.Q.Cancel()
-			}()
+			go r.shutdown(ctx.Q, r.done, true)
 			return
 		}
+		if err == nil {
+			f := cmdFields(ctx.Cmd, res.StatusCode, nil)
+			f["content_type"] = res.Header.Get("Content-Type")
+			if res.StatusCode >= 400 {
+				r.log().Warn("fetched", f)
+			} else {
+				r.log().Info("fetched", f)
+			}
+		}
 		wrapped.Handle(ctx, res, err)
 	})
 }
 
+// pageHandler parses the body of a successful html GET response that fetchbot downloaded,
+// emits the scraped document for index ingestion and enqueues the links found on the page.
+// The page is fetched and parsed a single time for both.
+func (r *Runner) pageHandler() fetchbot.Handler {
+	return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		var hash func() string
+		if r.PageStore != nil {
+			hash = hashBody(res)
+		}
+		span := r.startSpan(res, spanParse)
+		doc, err := parseResponse(res)
+		endSpan(span, err)
+		if err != nil {
+			r.log().Error("parsing failed", cmdFields(ctx.Cmd, res.StatusCode, err))
+			r.fail(ctx.Cmd, StageParse, ErrParse, res.StatusCode, err)
+			return
+		}
+
+		var directives RobotsDirectives
+		if !r.IgnoreRobotsDirectives {
+			directives = robotsDirectives(res, doc, r.UserAgent)
+		}
+
+		indexed := !directives.NoIndex && !directives.Expired(time.Now())
+		if !indexed {
+			r.log().Debug("noindex", cmdFields(ctx.Cmd, res.StatusCode, nil))
+		} else if d := r.scrape(ctx, res, doc); isEmpty(d) {
+			// don't index a blank Document in place of the page
+			r.log().Warn("empty document", cmdFields(ctx.Cmd, res.StatusCode, ErrEmptyDocument))
+			r.fail(ctx.Cmd, StageScrape, ErrEmptyDocument, res.StatusCode, nil)
+		} else {
+			d.Link = r.canonicalizer().Canonicalize(ctx.Cmd.URL()).String()
+			d.Sitemap = sitemapHint(ctx.Cmd)
+			if directives.NoArchive {
+				// keep the page indexable without storing a copy of its content
+				d.Content = ""
+			}
+			if hash != nil {
+				// the server didn't answer the conditional request but the content is the same
+				prev, err := r.PageStore.Get(r.canonicalizer().Key(ctx.Cmd.URL()))
+				d.Unchanged = err == nil && prev != nil && prev.Hash == hash()
+			}
+
+			if !r.emitPage(ctx, d) {
+				return
+			}
+		}
+
+		// Enqueue all links as HEAD requests
+		var links []string
+		if directives.NoFollow {
+			r.log().Debug("nofollow", cmdFields(ctx.Cmd, res.StatusCode, nil))
+		} else {
+			span := r.startSpan(res, spanLinks)
+			links = r.enqueueLinks(ctx, doc)
+			span.SetAttributes(attribute.Int("hermes.links", len(links)))
+			span.End()
+		}
+
+		// the pages that are not indexed are downloaded again on the next crawl
+		if hash != nil && indexed {
+			r.savePage(ctx, res, hash(), links)
+		}
+	})
+}
+
+// Disallowed returns the URLs of the last crawl that were disallowed by the hosts' robots.txt.
+func (r *Runner) Disallowed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.disallowed...)
+}
+
 // enqueueLinks will make sure we are adding links to the queue to be processed
 // for crawling and scraping. This will pull all of the hrefs within an html
 // page. The nature of this function will also check for duplicates that have
 // already been crawled and scraped. If they have not been added to the queue
-// they will be appended to the queue.
-func (r *Runner) enqueueLinks(ctx *fetchbot.Context, doc *goquery.Document) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// they will be appended to the queue. It returns the links found on the page.
+func (r *Runner) enqueueLinks(ctx *fetchbot.Context, doc *goquery.Document) []string {
+	var links []string
+	// relative links are resolved against the page's <base href> or the page URL
+	base := baseURL(ctx, doc)
+	depth, hops := linkInfo(ctx.Cmd)
 
 	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
 		val, exists := s.Attr("href")
 		if exists == false {
-			fmt.Println("[ERR]: address within the document")
+			r.log().Warn("link without an address", cmdFields(ctx.Cmd, 0, nil))
+			return
+		}
+
+		if !r.IgnoreRobotsDirectives && hasNoFollow(s) {
 			return
 		}
 
 		// Resolve address
-		u, err := url.Parse(val)
+		u, err := resolveLink(base, val)
 		if err != nil {
-			fmt.Printf("[ERR]: resolve URL %s - %s\n", u, err)
+			f := cmdFields(ctx.Cmd, 0, err)
+			f["href"] = val
+			r.log().Debug("unresolved link", f)
 			return
 		}
 
-		// check whether or not the link is an email link
-		emailCheck := false
-		func(s string, emailCheck *bool) {
-			if strings.Contains(s, "mailto:") {
-				*emailCheck = true
-			}
-		}(u.String(), &emailCheck)
+		links = append(links, u.String())
+		r.enqueueLink(ctx.Q, ctx.Cmd.URL(), u, depth+1, hops, nil)
+	})
+	return links
+}
 
-		if emailCheck == true {
-			fmt.Printf("[ERR] Email link - %s\n", u.String())
-			return
-		}
+// enqueueLink adds the link u found on the page to the queue as a HEAD request if it was not
+// seen yet and is in scope. The depth is the depth of the link and parentHops the external hops
+// of the page. The sitemap hint is nil for links that were not found in a sitemap.
+func (r *Runner) enqueueLink(q *fetchbot.Queue, page, u *url.URL, depth, parentHops int, hint *SitemapURL) {
+	// a revisit only fetches its seeds
+	if r.seeds != nil {
+		return
+	}
 
-		fragmentCheck := false
-		func(u *url.URL, fragmentCheck *bool) {
-			if u.Fragment != "" {
-				*fragmentCheck = true
-			}
-		}(u, &fragmentCheck)
+	if u = r.discovered(page, u); u == nil || r.skipped(u) {
+		return
+	}
 
-		if fragmentCheck == true {
-			fmt.Printf("[ERR] URL with fragment tag - %s\n", u.String())
-			return
-		}
+	r.smu.Lock()
+	defer r.smu.Unlock()
 
-		// remove the 'www' from the URL so that we have better duplicate detection
-		normalizeLink(u)
-
-		// catch the duplicate urls here before trying to add them to the queue
-		if !r.dup[u.String()] {
-			// tld & subdomain
-			if r.TopLevelDomain == true && r.Subdomain == true {
-				rootDomain := getDomain(r.URL.Host)
-				current := getDomain(u.Host)
-
-				if rootDomain == current {
-					if _, err := ctx.Q.SendStringHead(u.String()); err != nil {
-						return
-					}
-					r.dup[u.String()] = true
-					if err != nil {
-						fmt.Printf("[ERR]: enqueue head %s - %s\n", u, err)
-						return
-					}
-				} else {
-					fmt.Printf("catch: out of domain scope -- %s != %s\n", u.Host, r.URL.Host)
-				}
-			}
+	// the canonical key gives a better duplicate detection (www, scheme, query order, etc.)
+	key := r.canonicalizer().Key(u)
 
-			// tld check
-			if r.TopLevelDomain == true && r.Subdomain == false {
-				rootTLD := getDomain(r.URL.Host)
-				current := getTLD(u.Host)
-
-				if rootTLD == current {
-					if _, err := ctx.Q.SendStringHead(u.String()); err != nil {
-						return
-					}
-					r.dup[u.String()] = true
-					if err != nil {
-						fmt.Printf("[ERR]: enqueue head %s - %s\n", u, err)
-						return
-					}
-				}
-			}
+	// catch the duplicate urls here before trying to add them to the queue
+	seen, err := r.seen.Seen(key)
+	if err != nil {
+		r.log().Error("seen store failed", requestFields("", u, 0, err))
+		return
+	}
+	if seen {
+		r.Metrics.observeDuplicate()
+		return
+	}
 
-			// subdomain check
-			if r.Subdomain == true && r.TopLevelDomain == false {
-				rootDomain := getDomain(r.URL.Host)
-				current := getDomain(u.Host)
-
-				if rootDomain == current {
-					if _, err := ctx.Q.SendStringHead(u.String()); err != nil {
-						return
-					}
-					r.dup[u.String()] = true
-					if err != nil {
-						fmt.Printf("[ERR]: enqueue head %s - %s\n", u, err)
-						return
-					}
-				} else {
-					fmt.Printf("catch: out of domain scope -- %s != %s\n", u.Host, r.URL.Host)
-				}
-			}
-		}
-	})
+	hops, err := r.checkScope(u, depth, parentHops)
+	if err != nil {
+		r.stats.observeOutOfScope(err)
+		r.log().Debug("out of scope", requestFields("", u, 0, err))
+		return
+	}
+
+	// crawling the logout link would end the session
+	if r.isLogout(u) {
+		r.log().Debug("logout link skipped", requestFields("", u, 0, nil))
+		return
+	}
+
+	c := newLinkCmd("HEAD", u, depth, hops)
+	c.sitemap = hint
+	if err := r.send(q, c); err != nil {
+		r.log().Error("enqueue failed", requestFields("HEAD", u, 0, err))
+		return
+	}
+	if err := r.seen.Add(key); err != nil {
+		r.log().Error("seen store failed", requestFields("", u, 0, err))
+	}
+}
+
+// baseURL returns the URL that the relative links of a page are resolved against. That is the
+// document's <base href> if it has one (itself resolved against the page URL), otherwise the
+// URL the page was fetched from after redirects.
+func baseURL(ctx *fetchbot.Context, doc *goquery.Document) *url.URL {
+	page := ctx.Cmd.URL()
+	if doc.Url != nil {
+		page = doc.Url
+	}
+
+	href, exists := doc.Find("base[href]").First().Attr("href")
+	if !exists {
+		return page
+	}
+	b, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return page
+	}
+	return page.ResolveReference(b)
 }
 
-// remove the www from the URL host
-func normalizeLink(u *url.URL) {
-	s := strings.Split(u.Host, ".")
-	if s[0] == "www" {
-		u.Host = strings.Join(s[1:], ".")
+// resolveLink parses a raw href and resolves it against the base URL, which takes care of
+// relative ("/about", "../x") and protocol-relative ("//host/path") links. The fragment is
+// dropped since it points within the same page. Links that don't resolve to an http or https
+// URL (mailto:, javascript:, tel:, data:, ftp:, ...) return ErrUnsupportedScheme.
+func resolveLink(base *url.URL, href string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return nil, err
 	}
-	return
+	u = base.ResolveReference(u)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ErrUnsupportedScheme
+	}
+	if u.Host == "" {
+		return nil, ErrEmptyHost
+	}
+	u.Fragment = ""
+	return u, nil
 }
 
-// getDomain will parse a url and return the domain with the tld on it (ie. example.com)
-func getDomain(u string) string {
-	var root string
-	s := strings.Split(u, ".")
-	if len(s) == 0 {
-		root = u
-		return root
-	}
-	last := len(s) - 1
-	if last == 1 {
-		root = s[0] + "." + s[last]
-		return root
-	} else if last > 1 {
-		runnerUp := last - 1
-		root = s[runnerUp] + "." + s[last]
-	}
-	return root
+// save saves a store that can be saved to a file, like a CookieJar or a MemoryPageStore.
+func (r *Runner) save(name string, v interface{}) {
+	if s, ok := v.(interface {
+		Save() error
+	}); ok {
+		if err := s.Save(); err != nil {
+			r.log().Error("saving failed", Fields{"store": name, "error": err})
+		}
+	}
 }
 
-// getTLD will parse a url type and return the top-level domain (.com, .edu, .gov, etc.)
-func getTLD(u string) string {
-	var tld string
-	s := strings.Split(u, ".")
-	if len(s) == 0 {
-		tld = u
-		return tld
-	} else if len(s) > 0 {
-		last := len(s) - 1
-		tld = s[last]
-	}
-	tld = u
-	return tld
+// canonicalizer returns the Runner's Canonicalizer or the default one if it is not set.
+func (r *Runner) canonicalizer() *Canonicalizer {
+	if r.Canonicalizer == nil {
+		return NewCanonicalizer()
+	}
+	return r.Canonicalizer
 }
@@ -0,0 +1,116 @@
+package hermes
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/fetchbot"
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestResolveLink(t *testing.T) {
+	base := mustParse(t, "https://example.com/docs/guide/intro.html?x=1")
+	for _, c := range []struct {
+		href, want string
+		err        error
+	}{
+		// relative links
+		{"page.html", "https://example.com/docs/guide/page.html", nil},
+		{"../api/", "https://example.com/docs/api/", nil},
+		{"/about", "https://example.com/about", nil},
+		{"?y=2", "https://example.com/docs/guide/intro.html?y=2", nil},
+		{"", "https://example.com/docs/guide/intro.html?x=1", nil},
+		{"  /padded  ", "https://example.com/padded", nil},
+
+		// protocol-relative links take the scheme of the base
+		{"//cdn.example.org/lib.js", "https://cdn.example.org/lib.js", nil},
+		{"//other.example.com", "https://other.example.com", nil},
+
+		// absolute links
+		{"http://other.com/a/b", "http://other.com/a/b", nil},
+		{"HTTPS://Other.com/", "https://Other.com/", nil},
+
+		// the fragment is dropped
+		{"#top", "https://example.com/docs/guide/intro.html?x=1", nil},
+		{"/faq#answer", "https://example.com/faq", nil},
+
+		// other schemes
+		{"mailto:someone@example.com", "", ErrUnsupportedScheme},
+		{"javascript:void(0)", "", ErrUnsupportedScheme},
+		{"tel:+15555555555", "", ErrUnsupportedScheme},
+		{"ftp://example.com/file", "", ErrUnsupportedScheme},
+		{"data:text/plain,hi", "", ErrUnsupportedScheme},
+		{"http:///path", "", ErrEmptyHost},
+	} {
+		u, err := resolveLink(base, c.href)
+		if c.err != nil {
+			if err != c.err {
+				t.Errorf("resolveLink(%q): err = %v, want %v", c.href, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveLink(%q): %v", c.href, err)
+			continue
+		}
+		if u.String() != c.want {
+			t.Errorf("resolveLink(%q) = %q, want %q", c.href, u, c.want)
+		}
+	}
+
+	if _, err := resolveLink(base, "http://[::1"); err == nil {
+		t.Error("resolveLink of an invalid URL: expected an error")
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	for _, c := range []struct {
+		page, redirected, head, want string
+	}{
+		// without <base> the links are resolved against the page
+		{"https://example.com/a/page", "", "", "https://example.com/a/page"},
+		// or the URL the page was redirected to
+		{"https://example.com/old", "https://example.com/new/page", "", "https://example.com/new/page"},
+
+		// <base href> replaces the page URL, resolved against it
+		{"https://example.com/a/page", "", `<base href="https://static.example.com/b/">`, "https://static.example.com/b/"},
+		{"https://example.com/a/page", "", `<base href="/root/">`, "https://example.com/root/"},
+		{"https://example.com/a/page", "", `<base href="sub/">`, "https://example.com/a/sub/"},
+		{"https://example.com/a/page", "", `<base href="//cdn.example.org/c/">`, "https://cdn.example.org/c/"},
+		{"https://example.com/old", "https://example.com/new/page", `<base href="../x/">`, "https://example.com/x/"},
+		{"https://example.com/a/page", "", `<base href=" /spaced/ ">`, "https://example.com/spaced/"},
+
+		// only the first <base> counts, and only with an href
+		{"https://example.com/a/page", "", `<base href="/first/"><base href="/second/">`, "https://example.com/first/"},
+		{"https://example.com/a/page", "", `<base target="_blank">`, "https://example.com/a/page"},
+		{"https://example.com/a/page", "", `<base href="http://[::1">`, "https://example.com/a/page"},
+	} {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><head>" + c.head + "</head><body></body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.redirected != "" {
+			doc.Url = mustParse(t, c.redirected)
+		}
+		ctx := &fetchbot.Context{Cmd: newLinkCmd("GET", mustParse(t, c.page), 0, 0)}
+		if got := baseURL(ctx, doc).String(); got != c.want {
+			t.Errorf("baseURL(%s, %q) = %q, want %q", c.page, c.head, got, c.want)
+		}
+	}
+}
+
+func TestBaseURLResolveLink(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><head><base href="https://cdn.example.com/assets/"></head></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &fetchbot.Context{Cmd: newLinkCmd("GET", &url.URL{Scheme: "http", Host: "example.com", Path: "/page"}, 0, 0)}
+	u, err := resolveLink(baseURL(ctx, doc), "img/logo.png#x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.String(), "https://cdn.example.com/assets/img/logo.png"; got != want {
+		t.Errorf("link = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,204 @@
+package hermes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCron defines a cron expression that can't be parsed
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// A CronSchedule is a parsed cron expression.
+type CronSchedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+// cronField is the range of a field of a cron expression and its names, if any.
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronMinute = cronField{min: 0, max: 59}
+	cronHour   = cronField{min: 0, max: 23}
+	cronDom    = cronField{min: 1, max: 31}
+	cronMonth  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	cronDow = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// ParseCron parses a standard 5-field cron expression (minute, hour, day of month, month and
+// day of week), with lists ("1,15"), ranges ("9-17"), steps ("*/15") and names ("mon-fri",
+// "jan"), or one of the @yearly, @monthly, @weekly, @daily and @hourly macros. Like cron, a
+// day matches if either the day of month or the day of week matches when both are restricted.
+// An expression whose days of month are in none of its months (i.e. "0 0 30 2 *") is invalid.
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if macro, ok := cronMacros[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(macro)
+		}
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%s: %w", expr, ErrInvalidCron)
+	}
+
+	s := &CronSchedule{expr: expr}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{{&s.minute, cronMinute}, {&s.hour, cronHour}, {&s.dom, cronDom}, {&s.month, cronMonth}, {&s.dow, cronDow}} {
+		if *f.bits, err = parseCronField(fields[i], f.field); err != nil {
+			return nil, fmt.Errorf("%s: %w", expr, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domRestricted = !strings.HasPrefix(fields[2], "*") && fields[2] != "?"
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*") && fields[4] != "?"
+	if !(s.domRestricted && s.dowRestricted) && !s.hasDays() {
+		return nil, fmt.Errorf("%s: %w", expr, ErrInvalidCron)
+	}
+	return s, nil
+}
+
+// cronMonthDays is the number of days of every month, in a leap year.
+var cronMonthDays = [13]int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// hasDays returns true if a month of the schedule has one of its days of month.
+func (s *CronSchedule) hasDays() bool {
+	for m := 1; m <= 12; m++ {
+		// the bits of the days 1 to the last day of the month
+		days := uint64(1)<<uint(cronMonthDays[m]+1) - 2
+		if s.month&(1<<uint(m)) != 0 && s.dom&days != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCronField parses a field of a cron expression into a bitset of the values it matches.
+func parseCronField(s string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, ErrInvalidCron
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = f.value(part[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(part[i+1:]); err != nil {
+				return 0, err
+			}
+		default:
+			v, err := f.value(part)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			// "5/10" means from 5 to the end, every 10
+			if step == 1 {
+				hi = v
+			}
+		}
+		if lo > hi {
+			return 0, ErrInvalidCron
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or a name of the field.
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, ErrInvalidCron
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule, in the location of t. It
+// returns the zero time if nothing matches within 5 years (i.e. "0 0 29 2 */7", a leap day that
+// is a Sunday).
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches returns true if the day of t matches the day of month and day of week fields.
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// String returns the cron expression of the schedule.
+func (s *CronSchedule) String() string {
+	return s.expr
+}
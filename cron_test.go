@@ -0,0 +1,158 @@
+package hermes
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"0 0 30 2 *",
+		"0 0 31 4,6,9,11 *",
+		"0 0 30-31 feb *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@reboot",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q): expected an error", expr)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	for _, c := range []struct {
+		expr, from, want string
+	}{
+		// every minute, strictly after the time
+		{"* * * * *", "2024-01-01 10:07:00", "2024-01-01 10:08:00"},
+		{"* * * * *", "2024-01-01 10:07:59", "2024-01-01 10:08:00"},
+
+		// steps
+		{"*/15 * * * *", "2024-01-01 10:07:00", "2024-01-01 10:15:00"},
+		{"*/15 * * * *", "2024-01-01 10:15:30", "2024-01-01 10:30:00"},
+		{"*/15 * * * *", "2024-01-01 10:50:00", "2024-01-01 11:00:00"},
+		{"5/20 * * * *", "2024-01-01 10:00:00", "2024-01-01 10:05:00"},
+		{"5/20 * * * *", "2024-01-01 10:06:00", "2024-01-01 10:25:00"},
+		{"0 0-12/6 * * *", "2024-01-01 07:00:00", "2024-01-01 12:00:00"},
+
+		// lists and ranges
+		{"0,30 9 * * *", "2024-01-01 09:10:00", "2024-01-01 09:30:00"},
+		{"0 9-17 * * *", "2024-01-01 17:30:00", "2024-01-02 09:00:00"},
+
+		// names
+		{"0 9-17 * * mon-fri", "2024-01-06 12:00:00", "2024-01-08 09:00:00"},
+		{"30 4 1 jan *", "2024-01-01 05:00:00", "2025-01-01 04:30:00"},
+		{"0 0 1 JUN *", "2024-01-01 00:00:00", "2024-06-01 00:00:00"},
+
+		// Sunday is both 0 and 7
+		{"0 0 * * 0", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * 7", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+
+		// the day of month and the day of week match either one when both are restricted
+		{"0 0 13 * *", "2024-01-01 00:00:00", "2024-01-13 00:00:00"},
+		{"0 0 13 * fri", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 0 13 * fri", "2024-01-12 00:00:00", "2024-01-13 00:00:00"},
+		// like cron, a field starting with "*" is not restricted, so both must match
+		{"0 0 */10 * mon", "2024-01-01 00:00:00", "2024-03-11 00:00:00"},
+		{"0 0 ? * mon", "2024-01-02 00:00:00", "2024-01-08 00:00:00"},
+
+		// macros
+		{"@hourly", "2024-01-01 10:07:00", "2024-01-01 11:00:00"},
+		{"@daily", "2024-01-01 10:07:00", "2024-01-02 00:00:00"},
+		{"@midnight", "2024-01-01 10:07:00", "2024-01-02 00:00:00"},
+		{"@weekly", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"@monthly", "2024-01-15 00:00:00", "2024-02-01 00:00:00"},
+		{"@yearly", "2024-01-01 00:00:00", "2025-01-01 00:00:00"},
+		{"@annually", "2024-06-01 00:00:00", "2025-01-01 00:00:00"},
+
+		// month boundaries and leap years
+		{"0 0 31 * *", "2024-02-01 00:00:00", "2024-03-31 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+	} {
+		s, err := ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", c.expr, err)
+			continue
+		}
+		if got := s.Next(at(c.from)); !got.Equal(at(c.want)) {
+			t.Errorf("%q.Next(%s) = %s, want %s", c.expr, c.from, got, c.want)
+		}
+	}
+}
+
+func TestCronScheduleNextNever(t *testing.T) {
+	// the day of week starts with "*", so the day must be both a leap day and a Sunday: the next
+	// one is in 2032
+	s, err := ParseCron("0 0 29 2 */7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Next = %s, want the zero time", got)
+	}
+}
+
+func TestSchedulerSkipsScheduleNever(t *testing.T) {
+	s, err := NewScheduler(Settings{}, Sources{Links: []CustomSettings{
+		{RootLink: "http://example.com/", Schedule: "0 0 29 2 */7"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Configure = func(job string, r *Runner) {
+		t.Errorf("job %s started", job)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Run: err = %v, want context.DeadlineExceeded", err)
+	}
+	if h := s.History(); len(h) != 0 {
+		t.Errorf("History = %v, want no runs", h)
+	}
+}
+
+func TestCronScheduleNextLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	s, err := ParseCron("0 3 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2024, 1, 2, 3, 0, 0, 0, loc)
+	if got := s.Next(time.Date(2024, 1, 1, 12, 0, 0, 0, loc)); !got.Equal(want) || got.Location() != loc {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
+
+func TestCronScheduleString(t *testing.T) {
+	s, err := ParseCron("@daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != "@daily" {
+		t.Errorf("String = %q, want %q", s.String(), "@daily")
+	}
+}
@@ -0,0 +1,58 @@
+package hermes
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// domainHost returns the lowercased host name without its port, IPv6 brackets and trailing dot.
+func domainHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname(host)), ".")
+}
+
+// registrableDomain returns the registrable domain of a host, which is its effective top-level
+// domain plus one label according to the Public Suffix List (i.e. bbc.co.uk for news.bbc.co.uk).
+// IP addresses and hosts that are a public suffix themselves are returned as is.
+func registrableDomain(host string) string {
+	h := domainHost(host)
+	if net.ParseIP(h) != nil {
+		return h
+	}
+	d, err := publicsuffix.EffectiveTLDPlusOne(h)
+	if err != nil {
+		return h
+	}
+	return d
+}
+
+// effectiveTLD returns the effective top-level domain of a host according to the Public Suffix
+// List (i.e. .com, .edu, .co.uk). IP addresses don't have one and return an empty string.
+func effectiveTLD(host string) string {
+	h := domainHost(host)
+	if h == "" || net.ParseIP(h) != nil {
+		return ""
+	}
+	suffix, _ := publicsuffix.PublicSuffix(h)
+	return suffix
+}
+
+// sameDomain will compare two hosts based on the TopLevelDomain and Subdomain toggles of the
+// Runner. With Subdomain the hosts must share the same registrable domain, with only
+// TopLevelDomain they must share the same effective TLD and with neither they must be the same
+// host. Ports are ignored.
+func (r *Runner) sameDomain(root, host string) bool {
+	switch {
+	case r.Subdomain:
+		return registrableDomain(root) == registrableDomain(host)
+	case r.TopLevelDomain:
+		tld := effectiveTLD(root)
+		if tld == "" {
+			return domainHost(root) == domainHost(host)
+		}
+		return tld == effectiveTLD(host)
+	default:
+		return domainHost(root) == domainHost(host)
+	}
+}
@@ -0,0 +1,83 @@
+package hermes
+
+import "testing"
+
+func TestRegistrableDomain(t *testing.T) {
+	for host, want := range map[string]string{
+		"example.com":              "example.com",
+		"www.example.com":          "example.com",
+		"a.b.example.com":          "example.com",
+		"WWW.Example.COM":          "example.com",
+		"example.com.":             "example.com",
+		"example.com:8080":         "example.com",
+		"news.bbc.co.uk":           "bbc.co.uk",
+		"a.co.uk":                  "a.co.uk",
+		"x.a.co.uk":                "a.co.uk",
+		"foo.github.io":            "foo.github.io",
+		"bar.foo.github.io":        "foo.github.io",
+		"co.uk":                    "co.uk",
+		"localhost":                "localhost",
+		"127.0.0.1":                "127.0.0.1",
+		"127.0.0.1:8080":           "127.0.0.1",
+		"[::1]:8080":               "::1",
+		"xn--bcher-kva.example.de": "example.de",
+	} {
+		if got := registrableDomain(host); got != want {
+			t.Errorf("registrableDomain(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestEffectiveTLD(t *testing.T) {
+	for host, want := range map[string]string{
+		"example.com":     "com",
+		"news.bbc.co.uk":  "co.uk",
+		"a.co.uk":         "co.uk",
+		"example.com:443": "com",
+		"127.0.0.1":       "",
+		"[::1]":           "",
+		"":                "",
+	} {
+		if got := effectiveTLD(host); got != want {
+			t.Errorf("effectiveTLD(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestSameDomain(t *testing.T) {
+	for _, c := range []struct {
+		subdomain, tld bool
+		root, host     string
+		want           bool
+	}{
+		// Subdomain: the same registrable domain
+		{true, true, "example.com", "www.example.com", true},
+		{true, true, "www.example.com", "blog.example.com", true},
+		{true, true, "example.com", "example.com:8080", true},
+		{true, true, "example.com", "example.org", false},
+		{true, true, "a.co.uk", "b.co.uk", false},
+		{true, true, "x.a.co.uk", "y.a.co.uk", true},
+		{true, false, "foo.github.io", "bar.github.io", false},
+		{true, false, "127.0.0.1:8080", "127.0.0.1:9090", true},
+		{true, false, "127.0.0.1", "127.0.0.2", false},
+
+		// TopLevelDomain only: the same effective TLD
+		{false, true, "example.com", "other.com", true},
+		{false, true, "a.co.uk", "b.co.uk", true},
+		{false, true, "a.co.uk", "a.uk", false},
+		{false, true, "example.com", "example.org", false},
+		{false, true, "127.0.0.1", "127.0.0.1:8080", true},
+		{false, true, "127.0.0.1", "10.0.0.1", false},
+
+		// neither: the same host
+		{false, false, "example.com", "EXAMPLE.com.", true},
+		{false, false, "example.com", "example.com:8080", true},
+		{false, false, "example.com", "www.example.com", false},
+	} {
+		r := &Runner{Subdomain: c.subdomain, TopLevelDomain: c.tld}
+		if got := r.sameDomain(c.root, c.host); got != c.want {
+			t.Errorf("Subdomain %t, TopLevelDomain %t: sameDomain(%q, %q) = %t, want %t",
+				c.subdomain, c.tld, c.root, c.host, got, c.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/jtaylor32/hermes"
 )
 
@@ -21,6 +22,7 @@ func main() {
 	// override custom fields on the new Runner
 	r.URL = u
 	r.Tags = []string{"div", "h1", "p"}
+	r.Logger = hermes.NewLogrusLogger(logrus.StandardLogger())
 
 	// Start the Runner
 	i, b := r.Crawl()
@@ -33,6 +35,8 @@ func main() {
 		Host:  "http://localhost:9200",
 		Index: "hermes_index",
 		Type:  "hermes_type",
+
+		Logger: hermes.NewLogrusLogger(logrus.StandardLogger()),
 	}
 
 	// Start the storage ingest
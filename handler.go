@@ -0,0 +1,71 @@
+package hermes
+
+import (
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+// A DocumentHandler receives the Documents scraped by a Runner as soon as they are scraped.
+// If HandleDocument returns an error the crawl is cancelled and the error is returned by Crawl.
+type DocumentHandler interface {
+	HandleDocument(Document) error
+}
+
+// The DocumentHandlerFunc type is an adapter to allow the use of ordinary functions as
+// DocumentHandlers.
+type DocumentHandlerFunc func(Document) error
+
+// HandleDocument calls f(d).
+func (f DocumentHandlerFunc) HandleDocument(d Document) error {
+	return f(d)
+}
+
+// emit hands a scraped document over to the Runner's DocumentHandler, or appends it to the
+// ingestionSet if there is no handler. Calls to the handler are serialized and synchronous,
+// so a slow handler blocks the fetching goroutines and slows the crawl down.
+func (r *Runner) emit(d Document) error {
+	r.Metrics.observeDocument()
+	r.mu.Lock()
+	r.count++
+	if r.DocumentHandler == nil {
+		r.ingestionSet = append(r.ingestionSet, d)
+		r.mu.Unlock()
+		return nil
+	}
+	r.mu.Unlock()
+
+	r.hmu.Lock()
+	defer r.hmu.Unlock()
+	return r.DocumentHandler.HandleDocument(d)
+}
+
+// emitPage emits the document of a page, unless the OnDocument hook drops it. If the
+// DocumentHandler fails the crawl is cancelled and false is returned.
+func (r *Runner) emitPage(ctx *fetchbot.Context, d Document) bool {
+	if r.Hooks.OnDocument != nil && !r.Hooks.OnDocument(&d) {
+		r.log().Debug("document dropped", cmdFields(ctx.Cmd, 0, nil))
+		return true
+	}
+	if err := r.emit(d); err != nil {
+		r.log().Error("document handler failed", cmdFields(ctx.Cmd, 0, err))
+		r.setHandlerErr(err)
+		go r.shutdown(ctx.Q, r.done, true)
+		return false
+	}
+	return true
+}
+
+// limitReached returns true if the Runner has scraped its MaximumDocuments.
+func (r *Runner) limitReached(n int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return n > 0 && r.count >= n
+}
+
+// setHandlerErr records the first error returned by the DocumentHandler.
+func (r *Runner) setHandlerErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handlerErr == nil {
+		r.handlerErr = err
+	}
+}
@@ -0,0 +1,87 @@
+package hermes
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+// ErrSkipped defines a request that was skipped by the Runner's OnRequest hook
+var ErrSkipped = errors.New("request skipped")
+
+// Hooks are the functions a Runner calls at the stages of a crawl, to customize it without
+// replacing its handlers. Any of them can be nil. They are called from the fetching goroutines,
+// so they must be safe for concurrent use.
+type Hooks struct {
+	// OnRequest is called with every request before it is sent, robots.txt files and sitemaps included. It can
+	// change the request's headers. If it returns false the request is skipped and fails with ErrSkipped.
+	OnRequest func(req *http.Request) bool
+
+	// OnResponse is called with every response before it is handled. A retried link only calls it for its
+	// last attempt. The body must not be read.
+	OnResponse func(res *http.Response)
+
+	// OnLinkDiscovered is called with every link found on a page or in a sitemap, before the duplicate and scope
+	// checks. It returns the link to enqueue, which can be rewritten, or nil to reject it.
+	OnLinkDiscovered func(page, link *url.URL) *url.URL
+
+	// OnDocument is called with every Document before it is emitted. It can enrich the Document. If it returns
+	// false the Document is dropped, the links of its page are still followed.
+	OnDocument func(d *Document) bool
+
+	// OnError is called with every link that failed permanently (see Result).
+	OnError func(f Failure)
+}
+
+// A Middleware wraps the fetchbot Handler that dispatches the responses to the Runner's mux,
+// to run code before or after it, or instead of it. The Handler receives every response and
+// request error of the crawl, after the retries.
+type Middleware func(fetchbot.Handler) fetchbot.Handler
+
+// Use adds middlewares to the Runner. The first middleware added is the outermost one.
+func (r *Runner) Use(m ...Middleware) {
+	r.Middleware = append(r.Middleware, m...)
+}
+
+// middleware wraps the handler with the Runner's Middleware and OnResponse hook.
+func (r *Runner) middleware(h fetchbot.Handler) fetchbot.Handler {
+	if r.Hooks.OnResponse != nil {
+		wrapped := h
+		h = fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+			if err == nil {
+				r.Hooks.OnResponse(res)
+			}
+			wrapped.Handle(ctx, res, err)
+		})
+	}
+	for i := len(r.Middleware) - 1; i >= 0; i-- {
+		h = r.Middleware[i](h)
+	}
+	return h
+}
+
+// discovered returns the link to enqueue for a link found on the page, nil if the
+// OnLinkDiscovered hook rejects it.
+func (r *Runner) discovered(page, link *url.URL) *url.URL {
+	if r.Hooks.OnLinkDiscovered == nil {
+		return link
+	}
+	return r.Hooks.OnLinkDiscovered(page, link)
+}
+
+// hookDoer is a fetchbot Doer that calls the OnRequest hook of a Runner before the wrapped
+// Doer does the request.
+type hookDoer struct {
+	r    *Runner
+	doer fetchbot.Doer
+}
+
+// Do calls the hook and does the request, unless the hook skips it.
+func (d *hookDoer) Do(req *http.Request) (*http.Response, error) {
+	if !d.r.Hooks.OnRequest(req) {
+		return nil, ErrSkipped
+	}
+	return d.doer.Do(req)
+}
@@ -0,0 +1,101 @@
+package hermes
+
+import (
+	"net/url"
+
+	"github.com/PuerkitoBio/fetchbot"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Fields are the structured fields of a log entry (i.e. "url", "method", "status", "host" and
+// "error").
+type Fields map[string]interface{}
+
+// A Logger receives the leveled, structured log entries of a Runner, an Elasticsearch store, a
+// Revisitor or a Scheduler. Implementations must be safe for concurrent use.
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+}
+
+// NopLogger is a Logger that discards every entry. It is the default Logger, so embedding
+// hermes doesn't write anything to stdout.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, Fields) {}
+func (nopLogger) Info(string, Fields)  {}
+func (nopLogger) Warn(string, Fields)  {}
+func (nopLogger) Error(string, Fields) {}
+
+// logrusLogger is a Logger that writes to a logrus logger.
+type logrusLogger struct {
+	l log.FieldLogger
+}
+
+// NewLogrusLogger returns a Logger that writes to a logrus Logger or Entry, with the fields of
+// the entries as logrus fields. Use log.StandardLogger() for the global logrus logger.
+func NewLogrusLogger(l log.FieldLogger) Logger {
+	return logrusLogger{l: l}
+}
+
+// Debug writes a debug entry.
+func (l logrusLogger) Debug(msg string, fields Fields) {
+	l.l.WithFields(log.Fields(fields)).Debug(msg)
+}
+
+// Info writes an info entry.
+func (l logrusLogger) Info(msg string, fields Fields) {
+	l.l.WithFields(log.Fields(fields)).Info(msg)
+}
+
+// Warn writes a warning entry.
+func (l logrusLogger) Warn(msg string, fields Fields) {
+	l.l.WithFields(log.Fields(fields)).Warn(msg)
+}
+
+// Error writes an error entry.
+func (l logrusLogger) Error(msg string, fields Fields) {
+	l.l.WithFields(log.Fields(fields)).Error(msg)
+}
+
+// logger returns the Logger or the NopLogger if it is nil.
+func logger(l Logger) Logger {
+	if l == nil {
+		return NopLogger
+	}
+	return l
+}
+
+// log returns the Runner's Logger, or the NopLogger if it is not set.
+func (r *Runner) log() Logger {
+	return logger(r.Logger)
+}
+
+// requestFields returns the fields of a log entry about a request. The method, status and error
+// are left out when they are not set.
+func requestFields(method string, u *url.URL, status int, err error) Fields {
+	f := Fields{}
+	if method != "" {
+		f["method"] = method
+	}
+	if u != nil {
+		f["url"] = u.String()
+		f["host"] = u.Host
+	}
+	if status != 0 {
+		f["status"] = status
+	}
+	if err != nil {
+		f["error"] = err
+	}
+	return f
+}
+
+// cmdFields returns the fields of a log entry about the request of a command.
+func cmdFields(cmd fetchbot.Command, status int, err error) Fields {
+	return requestFields(cmd.Method(), cmd.URL(), status, err)
+}
@@ -0,0 +1,376 @@
+package hermes
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The buckets (in seconds) of the latency histograms.
+var (
+	fetchBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	bulkBuckets  = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+type (
+	// Metrics collects the metrics of the Runners and Elasticsearch stores it is set on, and
+	// exposes them in the Prometheus text format. It is an http.Handler, mount it on the /metrics
+	// path of your service or call ListenAndServe. A Metrics can be shared by several Runners.
+	Metrics struct {
+		mu         sync.Mutex
+		fetched    *counterVec
+		fetchErrs  *counterVec
+		bytes      *counterVec
+		latency    *histogramVec
+		duplicates *counterVec
+		documents  *counterVec
+		failures   *counterVec
+		bulks      *histogramVec
+		bulkErrs   *counterVec
+		stored     *counterVec
+		runners    map[*Runner]struct{}
+	}
+
+	// counterVec is a counter with labels, keyed by the label values joined with "\xff".
+	counterVec struct {
+		labels []string
+		values map[string]float64
+	}
+
+	// histogramVec is a histogram with labels, keyed like a counterVec.
+	histogramVec struct {
+		labels  []string
+		buckets []float64
+		series  map[string]*histogram
+	}
+
+	// histogram counts the observations per bucket. The counts are not cumulative.
+	histogram struct {
+		counts []uint64
+		count  uint64
+		sum    float64
+	}
+)
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		fetched:    newCounterVec("host", "method", "status"),
+		fetchErrs:  newCounterVec("host", "method"),
+		bytes:      newCounterVec("host"),
+		latency:    newHistogramVec(fetchBuckets, "host"),
+		duplicates: newCounterVec(),
+		documents:  newCounterVec(),
+		failures:   newCounterVec("stage", "type"),
+		bulks:      newHistogramVec(bulkBuckets),
+		bulkErrs:   newCounterVec(),
+		stored:     newCounterVec(),
+		runners:    make(map[*Runner]struct{}),
+	}
+}
+
+// ListenAndServe serves the metrics on the /metrics path of the address. It blocks like
+// http.ListenAndServe.
+func (m *Metrics) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	return http.ListenAndServe(addr, mux)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	m.mu.Lock()
+	frontier := 0
+	for r := range m.runners {
+		frontier += r.frontierSize()
+	}
+	m.fetched.write(&b, "hermes_pages_fetched_total", "Responses received, by host, method and status code.")
+	m.fetchErrs.write(&b, "hermes_fetch_errors_total", "Requests that failed without a response, by host and method.")
+	m.bytes.write(&b, "hermes_downloaded_bytes_total", "Bytes of response bodies downloaded, by host.")
+	m.latency.write(&b, "hermes_fetch_duration_seconds", "Time to the response headers, by host.")
+	writeGauge(&b, "hermes_frontier_links", "Links enqueued and not handled yet.", float64(frontier))
+	m.duplicates.write(&b, "hermes_duplicate_links_total", "Links discovered that were already seen.")
+	m.documents.write(&b, "hermes_documents_scraped_total", "Documents scraped.")
+	m.failures.write(&b, "hermes_failures_total", "Links that failed permanently, by stage and type.")
+	m.bulks.write(&b, "hermes_elasticsearch_bulk_duration_seconds", "Duration of the Elasticsearch bulk requests.")
+	m.bulkErrs.write(&b, "hermes_elasticsearch_bulk_failures_total", "Elasticsearch bulk requests that failed.")
+	m.stored.write(&b, "hermes_elasticsearch_documents_total", "Documents sent to Elasticsearch.")
+	m.mu.Unlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeGauge(&b, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	writeGauge(&b, "go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(mem.Alloc))
+	writeCounter(&b, "go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(mem.TotalAlloc))
+	writeGauge(&b, "go_memstats_sys_bytes", "Number of bytes obtained from the system.", float64(mem.Sys))
+	writeCounter(&b, "go_gc_cycles_total", "Number of completed GC cycles.", float64(mem.NumGC))
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// observeFetch records a response, or a request that failed with err.
+func (m *Metrics) observeFetch(req *http.Request, res *http.Response, err error, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	host := req.URL.Host
+	if err != nil {
+		m.fetchErrs.add(1, host, req.Method)
+		return
+	}
+	m.fetched.add(1, host, req.Method, strconv.Itoa(res.StatusCode))
+	m.latency.observe(latency.Seconds(), host)
+}
+
+// observeBytes records the bytes of a response body downloaded from the host.
+func (m *Metrics) observeBytes(host string, n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes.add(float64(n), host)
+}
+
+// observeDuplicate records a link that was already seen.
+func (m *Metrics) observeDuplicate() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.duplicates.add(1)
+}
+
+// observeDocument records a scraped Document.
+func (m *Metrics) observeDocument() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.documents.add(1)
+}
+
+// observeFailure records a link that failed permanently.
+func (m *Metrics) observeFailure(f Failure) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures.add(1, string(f.Stage), failureName(f.Type))
+}
+
+// observeBulk records an Elasticsearch bulk request of n Documents.
+func (m *Metrics) observeBulk(n int, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bulks.observe(d.Seconds())
+	if err != nil {
+		m.bulkErrs.add(1)
+		return
+	}
+	m.stored.add(float64(n))
+}
+
+// track adds the frontier of the Runner to the metrics while it crawls.
+func (m *Metrics) track(r *Runner, crawling bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if crawling {
+		m.runners[r] = struct{}{}
+	} else {
+		delete(m.runners, r)
+	}
+}
+
+// frontierSize returns the number of links enqueued and not handled yet.
+func (r *Runner) frontierSize() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+// failureName returns the name of the sentinel error of a Failure's Type, used as a label.
+func failureName(err error) string {
+	switch err {
+	case ErrRequest:
+		return "request"
+	case ErrTimeout:
+		return "timeout"
+	case ErrHTTPStatus:
+		return "status"
+	case ErrParse:
+		return "parse"
+	case ErrEmptyDocument:
+		return "empty"
+	case ErrLoginFailed:
+		return "login"
+	}
+	return "other"
+}
+
+func newCounterVec(labels ...string) *counterVec {
+	return &counterVec{labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) add(v float64, values ...string) {
+	c.values[strings.Join(values, "\xff")] += v
+}
+
+func (c *counterVec) write(b *strings.Builder, name, help string) {
+	writeHeader(b, name, help, "counter")
+	if len(c.labels) == 0 {
+		fmt.Fprintf(b, "%s %s\n", name, formatValue(c.values[""]))
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(b, "%s%s %s\n", name, formatLabels(c.labels, key, ""), formatValue(c.values[key]))
+	}
+}
+
+func newHistogramVec(buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(b *strings.Builder, name, help string) {
+	writeHeader(b, name, help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(h.labels) == 0 && len(keys) == 0 {
+		// an unlabeled histogram is exposed before its first observation
+		keys = append(keys, "")
+		h.series[""] = &histogram{counts: make([]uint64, len(h.buckets))}
+	}
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(h.labels, key, formatValue(le)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, formatLabels(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", name, formatLabels(h.labels, key, ""), formatValue(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", name, formatLabels(h.labels, key, ""), s.count)
+	}
+}
+
+func writeHeader(b *strings.Builder, name, help, typ string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeGauge(b *strings.Builder, name, help string, v float64) {
+	writeHeader(b, name, help, "gauge")
+	fmt.Fprintf(b, "%s %s\n", name, formatValue(v))
+}
+
+func writeCounter(b *strings.Builder, name, help string, v float64) {
+	writeHeader(b, name, help, "counter")
+	fmt.Fprintf(b, "%s %s\n", name, formatValue(v))
+}
+
+// formatLabels formats the labels of a series, with the le label of a histogram bucket if it
+// is not empty.
+func formatLabels(labels []string, key, le string) string {
+	var pairs []string
+	if len(labels) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, labels[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabel escapes the backslashes, double quotes and line feeds of a label value.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingBody counts the bytes read from a response body, and hands the count over to done
+// when it is closed.
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	once sync.Once
+	done func(n int64)
+}
+
+// Read reads from the body and counts the bytes.
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// Close closes the body and hands the count over.
+func (b *countingBody) Close() error {
+	b.once.Do(func() { b.done(b.n) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,195 @@
+package hermes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+// A PageState is what a Runner remembers of a page from its last crawl: the validators of the
+// response (ETag and Last-Modified), the hash of its content and the links found on it, which
+// are enqueued again when the page is not modified.
+type PageState struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+	Links        []string  `json:"links,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// A PageStore keeps the PageState of the pages crawled by a Runner, keyed by the canonical keys
+// of their links (see Canonicalizer.Key). Implementations must be safe for concurrent use.
+type PageStore interface {
+	// Get returns the state of the page, nil if the page is not in the store.
+	Get(key string) (*PageState, error)
+	// Put sets the state of the page.
+	Put(key string, s *PageState) error
+}
+
+// MemoryPageStore is an in-memory PageStore. It can be saved to a file and loaded back with
+// OpenPageStore, so that the next crawl only downloads the pages that changed.
+type MemoryPageStore struct {
+	path string
+
+	mu    sync.Mutex
+	pages map[string]*PageState
+}
+
+// NewMemoryPageStore returns an empty MemoryPageStore that is only kept in memory.
+func NewMemoryPageStore() *MemoryPageStore {
+	return &MemoryPageStore{pages: make(map[string]*PageState)}
+}
+
+// OpenPageStore returns a MemoryPageStore saved to the file at path, loading the pages already
+// in it. The file doesn't have to exist.
+func OpenPageStore(path string) (*MemoryPageStore, error) {
+	s := NewMemoryPageStore()
+	s.path = path
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.pages); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the state of the page, nil if the page is not in the store.
+func (s *MemoryPageStore) Get(key string) (*PageState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pages[key], nil
+}
+
+// Put sets the state of the page.
+func (s *MemoryPageStore) Put(key string, p *PageState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[key] = p
+	return nil
+}
+
+// Len returns the number of pages in the store.
+func (s *MemoryPageStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pages)
+}
+
+// Save writes the store to its file. The file is replaced atomically, like a Checkpoint. Save
+// does nothing for a store that was not opened with OpenPageStore.
+func (s *MemoryPageStore) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	data, err := json.Marshal(s.pages)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// conditional adds the If-None-Match and If-Modified-Since headers of the page's last crawl to
+// a GET command, so that an unchanged page is answered with a 304 Not Modified.
+func (r *Runner) conditional(c *linkCmd) {
+	if r.PageStore == nil || c.Method() != "GET" {
+		return
+	}
+	p, err := r.PageStore.Get(r.canonicalizer().Key(c.URL()))
+	if err != nil {
+		r.log().Error("page store failed", cmdFields(c, 0, err))
+		return
+	}
+	if p == nil {
+		return
+	}
+	c.header = make(http.Header)
+	if p.ETag != "" {
+		c.header.Set("If-None-Match", p.ETag)
+	}
+	if p.LastModified != "" {
+		c.header.Set("If-Modified-Since", p.LastModified)
+	}
+}
+
+// notModifiedHandler handles the 304 Not Modified responses to conditional GET requests. The
+// page is not scraped again: an unchanged Document is emitted and the links found on the page
+// during its last crawl are enqueued.
+func (r *Runner) notModifiedHandler() fetchbot.Handler {
+	return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		key := r.canonicalizer().Key(ctx.Cmd.URL())
+		p, err := r.PageStore.Get(key)
+		if err != nil || p == nil {
+			r.log().Error("page store failed", cmdFields(ctx.Cmd, res.StatusCode, err))
+			return
+		}
+
+		d := Document{
+			Link:      r.canonicalizer().Canonicalize(ctx.Cmd.URL()).String(),
+			Tag:       generateTag(ctx.Cmd.URL().Host),
+			Time:      time.Now(),
+			Unchanged: true,
+			Sitemap:   sitemapHint(ctx.Cmd),
+		}
+		if !r.emitPage(ctx, d) {
+			return
+		}
+
+		updated := *p
+		updated.Time = d.Time
+		if err := r.PageStore.Put(key, &updated); err != nil {
+			r.log().Error("page store failed", cmdFields(ctx.Cmd, res.StatusCode, err))
+		}
+
+		depth, hops := linkInfo(ctx.Cmd)
+		for _, l := range p.Links {
+			u, err := resolveLink(ctx.Cmd.URL(), l)
+			if err != nil {
+				continue
+			}
+			r.enqueueLink(ctx.Q, ctx.Cmd.URL(), u, depth+1, hops, nil)
+		}
+	})
+}
+
+// hashBody makes the body of the response compute its hash as it is read. The returned function
+// returns the hash once the body has been read.
+func hashBody(res *http.Response) func() string {
+	h := sha256.New()
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(res.Body, h), res.Body}
+	return func() string {
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// savePage records the validators, content hash and links of a page that was downloaded.
+func (r *Runner) savePage(ctx *fetchbot.Context, res *http.Response, hash string, links []string) {
+	p := &PageState{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+		Hash:         hash,
+		Links:        links,
+		Time:         time.Now(),
+	}
+	if err := r.PageStore.Put(r.canonicalizer().Key(ctx.Cmd.URL()), p); err != nil {
+		r.log().Error("page store failed", cmdFields(ctx.Cmd, res.StatusCode, err))
+	}
+}
@@ -15,6 +15,7 @@ type (
 		Tags           []string `json:"tags"`
 		Subdomain      bool     `json:"subdomain"`
 		TopLevelDomain bool     `json:"top_level_domain"`
+		Schedule       string   `json:"schedule"` // cron expression of the crawl (i.e. "0 3 * * *"), see ParseCron
 	}
 
 	// Sources struct to model a Type we want to ingest into the elasticsearch index
@@ -38,6 +39,7 @@ type (
 		WorkerIdleTTL      time.Duration `json:"worker_timeout"`     // time-to-live for a host URL's goroutine
 		AutoClose          bool          `json:"autoclose"`          // sets the application to terminate if the WorkerIdleTTL time is passed (must be true)
 		EnableLogging      bool          `json:"enable_logging"`     // sets whether or not to log to a file
+		FailureThreshold   float64       `json:"failure_threshold"`  // fraction of failed links that fails the crawl (i.e. 0.5)
 	}
 )
 
@@ -86,3 +88,38 @@ func ParseSettings() Settings {
 
 	return s
 }
+
+// Runner returns a new Runner configured with the Settings. The zero values of the Settings keep
+// the defaults of New, except for AutoClose which is always set.
+func (s Settings) Runner() *Runner {
+	r := New()
+	if s.CrawlDelay != 0 {
+		r.CrawlDelay = s.CrawlDelay
+	}
+	if s.CancelDuration != 0 {
+		r.CancelDuration = s.CancelDuration
+	}
+	if s.CancelAtURL != "" {
+		r.CancelAtURL = s.CancelAtURL
+	}
+	if s.StopDuration != 0 {
+		r.StopDuration = s.StopDuration
+	}
+	if s.StopAtURL != "" {
+		r.StopAtURL = s.StopAtURL
+	}
+	if s.MemStatsInterval != 0 {
+		r.MemStatsInterval = s.MemStatsInterval
+	}
+	if s.UserAgent != "" {
+		r.UserAgent = s.UserAgent
+	}
+	if s.WorkerIdleTTL != 0 {
+		r.WorkerIdleTTL = s.WorkerIdleTTL
+	}
+	if s.FailureThreshold != 0 {
+		r.FailureThreshold = s.FailureThreshold
+	}
+	r.AutoClose = s.AutoClose
+	return r
+}
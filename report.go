@@ -0,0 +1,366 @@
+package hermes
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+// reportPages is the number of slowest and largest pages kept in a Report.
+const reportPages = 10
+
+type (
+	// A Report summarizes a crawl for humans and CI runs. It is written as JSON with WriteJSON
+	// and as a self-contained HTML page with WriteHTML, or to the ReportPath of the Runner at the
+	// end of every crawl.
+	Report struct {
+		URL      string        `json:"url"`
+		Start    time.Time     `json:"start"`
+		End      time.Time     `json:"end"`
+		Duration time.Duration `json:"duration_ns"`
+
+		// The Requests is the number of requests sent, retries, HEAD requests, robots.txt files and
+		// sitemaps included.
+		Requests  int   `json:"requests"`
+		Links     int   `json:"links"`
+		Documents int   `json:"documents"`
+		Bytes     int64 `json:"bytes"`
+
+		// The StatusCodes is the number of responses by status code.
+		StatusCodes map[int]int  `json:"status_codes"`
+		Hosts       []HostReport `json:"hosts"`
+
+		// The Slowest and Largest are the slowest and largest pages, the HEAD requests, robots.txt
+		// files and sitemaps excluded.
+		Slowest []PageReport `json:"slowest"`
+		Largest []PageReport `json:"largest"`
+
+		// The Redirects is the number of responses that were redirected or have a 3xx status code.
+		Redirects  int `json:"redirects"`
+		Disallowed int `json:"disallowed"`
+
+		// The OutOfScope is the number of links rejected by the scope, by reason (the message of the
+		// ScopeError's Err).
+		OutOfScope     map[string]int `json:"out_of_scope"`
+		EmptyDocuments []string       `json:"empty_documents"`
+		Failures       []Failure      `json:"failures"`
+	}
+
+	// A HostReport is the breakdown of the requests of a crawl to one host.
+	HostReport struct {
+		Host        string        `json:"host"`
+		Requests    int           `json:"requests"`
+		Errors      int           `json:"errors"`
+		Bytes       int64         `json:"bytes"`
+		StatusCodes map[int]int   `json:"status_codes"`
+		Latency     time.Duration `json:"average_latency_ns"`
+
+		// latency is the total latency of the responses
+		latency time.Duration
+		// responses is the number of responses
+		responses int
+	}
+
+	// A PageReport is a response of a crawl, with its latency to the headers and the size of its
+	// body.
+	PageReport struct {
+		URL     string        `json:"url"`
+		Method  string        `json:"method"`
+		Status  int           `json:"status"`
+		Latency time.Duration `json:"latency_ns"`
+		Bytes   int64         `json:"bytes"`
+	}
+
+	// crawlStats collects the statistics of a crawl for its Report.
+	crawlStats struct {
+		mu         sync.Mutex
+		requests   int
+		bytes      int64
+		statuses   map[int]int
+		hosts      map[string]*HostReport
+		slowest    []PageReport
+		largest    []PageReport
+		redirects  int
+		outOfScope map[string]int
+	}
+)
+
+// Report returns the report of the last crawl, nil if the Runner didn't crawl yet.
+func (r *Runner) Report() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.report
+}
+
+// WriteJSON writes the report as indented JSON.
+func (rep *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// WriteHTML writes the report as an HTML page that needs no other resource.
+func (rep *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, rep)
+}
+
+// saveReport writes the report of the last crawl to the ReportPath, as JSON and HTML.
+func (r *Runner) saveReport() {
+	rep := r.Report()
+	for _, f := range []struct {
+		ext   string
+		write func(io.Writer) error
+	}{{".json", rep.WriteJSON}, {".html", rep.WriteHTML}} {
+		var b bytes.Buffer
+		err := f.write(&b)
+		if err == nil {
+			err = writeFileAtomic(r.ReportPath+f.ext, b.Bytes())
+		}
+		if err != nil {
+			r.log().Error("saving report failed", Fields{"path": r.ReportPath + f.ext, "error": err.Error()})
+		}
+	}
+}
+
+// newReport returns the report of the crawl of the result. The lock must be held.
+func (r *Runner) newReport(res *CrawlResult) *Report {
+	s := r.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rep := &Report{
+		Start:       res.Start,
+		End:         res.End,
+		Duration:    res.End.Sub(res.Start),
+		Requests:    s.requests,
+		Links:       res.Links,
+		Documents:   res.Documents,
+		Bytes:       s.bytes,
+		StatusCodes: make(map[int]int),
+		Slowest:     append([]PageReport(nil), s.slowest...),
+		Largest:     append([]PageReport(nil), s.largest...),
+		Redirects:   s.redirects,
+		Disallowed:  len(res.Disallowed),
+		OutOfScope:  make(map[string]int),
+		Failures:    res.Failures,
+	}
+	if r.URL != nil {
+		rep.URL = r.URL.String()
+	}
+	for code, n := range s.statuses {
+		rep.StatusCodes[code] = n
+	}
+	for reason, n := range s.outOfScope {
+		rep.OutOfScope[reason] = n
+	}
+	for _, h := range s.hosts {
+		hr := *h
+		hr.StatusCodes = make(map[int]int)
+		for code, n := range h.StatusCodes {
+			hr.StatusCodes[code] = n
+		}
+		if hr.responses > 0 {
+			hr.Latency = hr.latency / time.Duration(hr.responses)
+		}
+		rep.Hosts = append(rep.Hosts, hr)
+	}
+	sort.Slice(rep.Hosts, func(i, j int) bool { return rep.Hosts[i].Host < rep.Hosts[j].Host })
+	for _, f := range res.Failures {
+		if f.Type == ErrEmptyDocument {
+			rep.EmptyDocuments = append(rep.EmptyDocuments, f.URL)
+		}
+	}
+	return rep
+}
+
+func newCrawlStats() *crawlStats {
+	return &crawlStats{
+		statuses:   make(map[int]int),
+		hosts:      make(map[string]*HostReport),
+		outOfScope: make(map[string]int),
+	}
+}
+
+// host returns the report of the host, added if it is missing. The lock must be held.
+func (s *crawlStats) host(host string) *HostReport {
+	h := s.hosts[host]
+	if h == nil {
+		h = &HostReport{Host: host, StatusCodes: make(map[int]int)}
+		s.hosts[host] = h
+	}
+	return h
+}
+
+// observeFetch records a response, or a request that failed with err.
+func (s *crawlStats) observeFetch(req *http.Request, res *http.Response, err error, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	h := s.host(req.URL.Host)
+	h.Requests++
+	if err != nil {
+		h.Errors++
+		return
+	}
+	s.statuses[res.StatusCode]++
+	h.StatusCodes[res.StatusCode]++
+	h.latency += latency
+	h.responses++
+	if res.StatusCode >= 300 && res.StatusCode < 400 ||
+		res.Request != nil && res.Request.URL.String() != req.URL.String() {
+		s.redirects++
+	}
+}
+
+// observeBody records the size of the body of a response from the host. The responses of pages
+// are ranked by latency and size too.
+func (s *crawlStats) observeBody(host string, p PageReport, page bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes += p.Bytes
+	s.host(host).Bytes += p.Bytes
+	if !page {
+		return
+	}
+	s.slowest = topPages(s.slowest, p, func(a, b PageReport) bool { return a.Latency > b.Latency })
+	s.largest = topPages(s.largest, p, func(a, b PageReport) bool { return a.Bytes > b.Bytes })
+}
+
+// observeOutOfScope records a link rejected by the scope with the error.
+func (s *crawlStats) observeOutOfScope(err error) {
+	reason := err.Error()
+	if se, ok := err.(*ScopeError); ok {
+		reason = se.Err.Error()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outOfScope[reason]++
+}
+
+// topPages adds the page to the pages sorted by less, and keeps the first reportPages.
+func topPages(pages []PageReport, p PageReport, less func(a, b PageReport) bool) []PageReport {
+	i := sort.Search(len(pages), func(i int) bool { return less(p, pages[i]) })
+	if i >= reportPages {
+		return pages
+	}
+	pages = append(pages, PageReport{})
+	copy(pages[i+1:], pages[i:])
+	pages[i] = p
+	if len(pages) > reportPages {
+		pages = pages[:reportPages]
+	}
+	return pages
+}
+
+// statsDoer is a fetchbot Doer that records the responses, latency and bytes downloaded of the
+// requests of the wrapped Doer, for the Report and the Metrics of a Runner. Only the pages make
+// the slowest and largest pages of the Report. The logins don't go through it.
+type statsDoer struct {
+	r    *Runner
+	doer fetchbot.Doer
+}
+
+// Do does the request and records it.
+func (d *statsDoer) Do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := d.doer.Do(req)
+	latency := time.Since(start)
+	d.r.Metrics.observeFetch(req, res, err, latency)
+	d.r.stats.observeFetch(req, res, err, latency)
+	if err != nil {
+		return res, err
+	}
+
+	p := PageReport{URL: req.URL.String(), Method: req.Method, Status: res.StatusCode, Latency: latency}
+	page := d.r.isPage(req)
+	res.Body = &countingBody{ReadCloser: res.Body, done: func(n int64) {
+		p.Bytes = n
+		d.r.Metrics.observeBytes(req.URL.Host, n)
+		d.r.stats.observeBody(req.URL.Host, p, page)
+	}}
+	return res, nil
+}
+
+// isPage returns true if the request fetches a page, as opposed to a HEAD probe, a robots.txt
+// file or a sitemap.
+func (r *Runner) isPage(req *http.Request) bool {
+	if req.Method != "GET" || req.URL.Path == "/robots.txt" {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.sitemaps[req.URL.String()]
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"seconds": func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>hermes report {{.URL}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { padding: 2px 12px; text-align: left; border-bottom: 1px solid #ddd; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>hermes report</h1>
+<table>
+<tr><th>URL</th><td>{{.URL}}</td></tr>
+<tr><th>Start</th><td>{{.Start.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Duration</th><td>{{seconds .Duration}}</td></tr>
+<tr><th>Requests</th><td class="n">{{.Requests}}</td></tr>
+<tr><th>Links</th><td class="n">{{.Links}}</td></tr>
+<tr><th>Documents</th><td class="n">{{.Documents}}</td></tr>
+<tr><th>Bytes</th><td class="n">{{.Bytes}}</td></tr>
+<tr><th>Redirects</th><td class="n">{{.Redirects}}</td></tr>
+<tr><th>Disallowed by robots.txt</th><td class="n">{{.Disallowed}}</td></tr>
+<tr><th>Failures</th><td class="n">{{len .Failures}}</td></tr>
+</table>
+<h2>Status codes</h2>
+<table>
+<tr><th>Status</th><th>Responses</th></tr>
+{{range $code, $n := .StatusCodes}}<tr><td>{{$code}}</td><td class="n">{{$n}}</td></tr>
+{{end}}</table>
+<h2>Hosts</h2>
+<table>
+<tr><th>Host</th><th>Requests</th><th>Errors</th><th>Bytes</th><th>Average latency</th><th>Status codes</th></tr>
+{{range .Hosts}}<tr><td>{{.Host}}</td><td class="n">{{.Requests}}</td><td class="n">{{.Errors}}</td><td class="n">{{.Bytes}}</td><td class="n">{{seconds .Latency}}</td><td>{{range $code, $n := .StatusCodes}}{{$code}}: {{$n}} {{end}}</td></tr>
+{{end}}</table>
+<h2>Slowest pages</h2>
+<table>
+<tr><th>URL</th><th>Method</th><th>Status</th><th>Latency</th></tr>
+{{range .Slowest}}<tr><td>{{.URL}}</td><td>{{.Method}}</td><td>{{.Status}}</td><td class="n">{{seconds .Latency}}</td></tr>
+{{end}}</table>
+<h2>Largest pages</h2>
+<table>
+<tr><th>URL</th><th>Method</th><th>Status</th><th>Bytes</th></tr>
+{{range .Largest}}<tr><td>{{.URL}}</td><td>{{.Method}}</td><td>{{.Status}}</td><td class="n">{{.Bytes}}</td></tr>
+{{end}}</table>
+<h2>Out of scope</h2>
+<table>
+<tr><th>Reason</th><th>Links</th></tr>
+{{range $reason, $n := .OutOfScope}}<tr><td>{{$reason}}</td><td class="n">{{$n}}</td></tr>
+{{end}}</table>
+<h2>Empty documents</h2>
+<ul>
+{{range .EmptyDocuments}}<li>{{.}}</li>
+{{end}}</ul>
+<h2>Failures</h2>
+<table>
+<tr><th>URL</th><th>Method</th><th>Stage</th><th>Status</th><th>Attempts</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{.URL}}</td><td>{{.Method}}</td><td>{{.Stage}}</td><td>{{if .StatusCode}}{{.StatusCode}}{{end}}</td><td class="n">{{.Attempts}}</td><td>{{if .Err}}{{.Err}}{{else}}{{.Type}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
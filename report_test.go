@@ -0,0 +1,53 @@
+package hermes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReportPages(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nAllow: /\nSitemap: %s/sitemap.xml\n", srv.URL)
+		case "/sitemap.xml":
+			// larger than the pages
+			fmt.Fprintf(w, `<urlset><url><loc>%s/a</loc></url><!-- %s --></urlset>`, srv.URL, strings.Repeat("x", 10000))
+		case "/", "/a":
+			fmt.Fprintf(w, `<html><head><title>%s</title></head><body><p>page</p><a href="/a">a</a></body></html>`, req.URL.Path)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	r := New()
+	r.URL, _ = url.Parse(srv.URL + "/")
+	r.CrawlDelay = 0
+	r.WorkerIdleTTL = 1
+	r.Throttle = nil
+	r.Sitemaps = true
+	if _, err := r.Crawl(); err != nil {
+		t.Fatal(err)
+	}
+
+	rep := r.Report()
+	if rep.Requests < 4 || rep.Bytes < 10000 {
+		t.Errorf("Requests, Bytes = %d, %d, want the robots.txt and sitemap counted", rep.Requests, rep.Bytes)
+	}
+	for name, pages := range map[string][]PageReport{"Slowest": rep.Slowest, "Largest": rep.Largest} {
+		if len(pages) != 2 {
+			t.Errorf("%s = %v, want the 2 pages", name, pages)
+		}
+		for _, p := range pages {
+			if path := strings.TrimPrefix(p.URL, srv.URL); p.Method != "GET" || path != "/" && path != "/a" {
+				t.Errorf("%s has %s %s, want only the pages", name, p.Method, p.URL)
+			}
+		}
+	}
+}
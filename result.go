@@ -0,0 +1,191 @@
+package hermes
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrRequest defines a request that failed without a response
+	ErrRequest = errors.New("request failed")
+	// ErrTimeout defines a request that timed out
+	ErrTimeout = errors.New("request timed out")
+	// ErrHTTPStatus defines a response with an error status code
+	ErrHTTPStatus = errors.New("error status code")
+	// ErrParse defines a page whose html can't be parsed
+	ErrParse = errors.New("page can't be parsed")
+	// ErrEmptyDocument defines a page without any title, description or content to scrape
+	ErrEmptyDocument = errors.New("nothing scraped from the page")
+	// ErrTooManyFailures defines a crawl in which more links failed than the FailureThreshold allows
+	ErrTooManyFailures = errors.New("too many failed links")
+	// ErrSeedFailed defines a crawl whose seed URL failed
+	ErrSeedFailed = errors.New("seed url failed")
+)
+
+// A FailureStage is the stage of the crawl a link failed at.
+type FailureStage string
+
+// The stages of the crawl a link can fail at.
+const (
+	StageFetch  FailureStage = "fetch"
+	StageParse  FailureStage = "parse"
+	StageScrape FailureStage = "scrape"
+	StageLogin  FailureStage = "login"
+)
+
+type (
+	// A Failure is a link that failed permanently: its request failed with an error that is not
+	// retryable or after all the attempts of the RetryPolicy, or its page could not be parsed or
+	// scraped. Err is nil if the link failed with a status code.
+	Failure struct {
+		URL    string
+		Method string
+
+		// The Stage is the stage of the crawl the link failed at.
+		Stage FailureStage
+
+		// The Type is the sentinel error of the kind of failure (ErrRequest, ErrTimeout, ErrHTTPStatus, ErrParse,
+		// ErrEmptyDocument or ErrLoginFailed), so that failures can be told apart with ==.
+		Type error
+
+		StatusCode int
+		Attempts   int
+		Err        error
+	}
+
+	// A CrawlResult is the outcome of a crawl: the number of links fetched and of Documents
+	// scraped, and the links that failed.
+	CrawlResult struct {
+		Start time.Time
+		End   time.Time
+
+		// The Links is the number of links fetched, whatever their response. A link retried counts once.
+		Links int
+
+		// The Documents is the number of Documents scraped, the ones streamed to a DocumentHandler included.
+		Documents int
+
+		Failures   []Failure
+		Disallowed []string
+	}
+)
+
+// MarshalJSON encodes the failure with its Type as a name (request, timeout, status, parse, empty
+// or login) and its Err as a message.
+func (f Failure) MarshalJSON() ([]byte, error) {
+	v := struct {
+		URL        string       `json:"url"`
+		Method     string       `json:"method"`
+		Stage      FailureStage `json:"stage"`
+		Type       string       `json:"type"`
+		StatusCode int          `json:"status,omitempty"`
+		Attempts   int          `json:"attempts"`
+		Err        string       `json:"error,omitempty"`
+	}{URL: f.URL, Method: f.Method, Stage: f.Stage, Type: failureName(f.Type), StatusCode: f.StatusCode, Attempts: f.Attempts}
+	if f.Err != nil {
+		v.Err = f.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// FailureRate returns the fraction of the links that failed, 0 if no link was fetched.
+func (c *CrawlResult) FailureRate() float64 {
+	if c.Links == 0 {
+		return 0
+	}
+	rate := float64(len(c.Failures)) / float64(c.Links)
+	if rate > 1 {
+		rate = 1
+	}
+	return rate
+}
+
+// Result returns the outcome of the last crawl, nil if the Runner didn't crawl yet.
+func (r *Runner) Result() *CrawlResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.result
+}
+
+// Failed returns the links of the last crawl that failed permanently.
+func (r *Runner) Failed() []Failure {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Failure(nil), r.failed...)
+}
+
+// fail records the link of a command that failed permanently.
+func (r *Runner) fail(cmd fetchbot.Command, stage FailureStage, typ error, status int, err error) {
+	f := Failure{URL: cmd.URL().String(), Method: cmd.Method(), Stage: stage, Type: typ, StatusCode: status, Attempts: 1, Err: err}
+	if c, ok := cmd.(*linkCmd); ok {
+		f.Attempts = c.attempt + 1
+	}
+	r.mu.Lock()
+	r.failed = append(r.failed, f)
+	r.mu.Unlock()
+
+	r.Metrics.observeFailure(f)
+	if r.Hooks.OnError != nil {
+		r.Hooks.OnError(f)
+	}
+}
+
+// fetched counts the link of a command whose final response (or error) was received. The GET
+// request of a link already probed with a HEAD request doesn't count again.
+func (r *Runner) fetched(c *linkCmd) {
+	if c.probed && c.Method() == "GET" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.links++
+}
+
+// seedFailed returns true if the seed URL of the crawl could not be fetched, logged into or
+// parsed. A seed that nothing could be scraped from still has links to follow. A revisit has no
+// seed URL. The lock must be held.
+func (r *Runner) seedFailed() bool {
+	if r.seeds != nil || r.URL == nil {
+		return false
+	}
+	seed := r.URL.String()
+	for _, f := range r.failed {
+		if f.URL == seed && f.Stage != StageScrape {
+			return true
+		}
+	}
+	return false
+}
+
+// newResult returns the result of the crawl that started at start. The lock must be held.
+func (r *Runner) newResult(start time.Time) *CrawlResult {
+	return &CrawlResult{
+		Start:      start,
+		End:        time.Now(),
+		Links:      r.links,
+		Documents:  r.count,
+		Failures:   append([]Failure(nil), r.failed...),
+		Disallowed: append([]string(nil), r.disallowed...),
+	}
+}
+
+// failureType returns the sentinel error of a request that failed with the error, or with an
+// error status code if err is nil. The error may wrap the cause, like the *url.Error of a client
+// timeout or a failed login.
+func failureType(err error) error {
+	var ne net.Error
+	switch {
+	case err == nil:
+		return ErrHTTPStatus
+	case errors.Is(err, ErrLoginFailed):
+		return ErrLoginFailed
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return ErrTimeout
+	}
+	return ErrRequest
+}
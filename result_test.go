@@ -0,0 +1,40 @@
+package hermes
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestFailureType(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want error
+	}{
+		{nil, ErrHTTPStatus},
+		{context.DeadlineExceeded, ErrTimeout},
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: context.DeadlineExceeded}, ErrTimeout},
+		{fmt.Errorf("fetch: %w", context.DeadlineExceeded), ErrTimeout},
+		{timeoutError{}, ErrTimeout},
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: timeoutError{}}, ErrTimeout},
+		{fmt.Errorf("login: %w", &url.Error{Op: "Post", URL: "http://example.com/login", Err: timeoutError{}}), ErrTimeout},
+		{fmt.Errorf("POST http://example.com/login - 403 Forbidden: %w", ErrLoginFailed), ErrLoginFailed},
+		{context.Canceled, ErrRequest},
+		{errors.New("connection refused"), ErrRequest},
+		{&url.Error{Op: "Get", URL: "http://example.com/", Err: errors.New("connection reset")}, ErrRequest},
+	} {
+		if got := failureType(c.err); got != c.want {
+			t.Errorf("failureType(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
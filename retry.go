@@ -0,0 +1,193 @@
+package hermes
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+type (
+	// A RetryPolicy defines how a Runner retries the requests that fail with a transient error
+	// (timeouts, connection resets, etc.) or a transient status code (5xx, 429). Retries are
+	// re-enqueued in the fetchbot Queue, so the crawl delay, throttling and robots.txt policies of
+	// the host still apply. Unlike the Runner's fields, the durations of a RetryPolicy are real
+	// durations (i.e. 500 * time.Millisecond).
+	RetryPolicy struct {
+		// The MaxAttempts is the maximum number of times a request is attempted, the first attempt included.
+		// A value of 1 or less disables the retries.
+		MaxAttempts int
+
+		// The BaseDelay is the delay before the first retry. It doubles with every attempt.
+		BaseDelay time.Duration
+
+		// The MaxDelay caps the delay between two attempts.
+		MaxDelay time.Duration
+
+		// The Jitter is the fraction (0 to 1) of the delay that is randomized, so that the retries of many
+		// failing links don't all happen at once.
+		Jitter float64
+
+		// The StatusCodes are the response status codes that are retried.
+		StatusCodes []int
+
+		// RetryableError returns true if a request error is worth retrying. If it is nil IsTransientError is used.
+		RetryableError func(error) bool
+	}
+)
+
+// NewRetryPolicy returns a RetryPolicy that retries 408, 429, 500, 502, 503 and 504 responses
+// and transient errors up to 3 attempts. These values can be overwritten after initializing the
+// new RetryPolicy reference.
+func NewRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		Jitter:      0.5,
+		StatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// Backoff returns the delay before the given retry (1 for the first retry), doubled with every
+// attempt, capped at the MaxDelay and reduced by up to Jitter of itself.
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		j := p.Jitter
+		if j > 1 {
+			j = 1
+		}
+		d -= time.Duration(rand.Float64() * j * float64(d))
+	}
+	return d
+}
+
+// retryable returns true if the response or error of an attempt is worth retrying.
+func (p *RetryPolicy) retryable(res *http.Response, err error) bool {
+	if err != nil {
+		if p.RetryableError != nil {
+			return p.RetryableError(err)
+		}
+		return IsTransientError(err)
+	}
+	for _, code := range p.StatusCodes {
+		if res.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTransientError returns true if a request error is likely to go away on its own: timeouts,
+// temporary DNS failures, refused or reset connections and connections closed mid-response.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, fetchbot.ErrDisallowed) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	for _, target := range []error{io.EOF, io.ErrUnexpectedEOF, syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// sleep waits for the delay, and returns false if the crawl is cancelled first.
+func (r *Runner) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// retryHandler re-enqueues the links whose attempt failed with a retryable error or status code,
+// after the backoff delay of the RetryPolicy. The host's worker waits for the delay, which also
+// holds off the other requests to a failing host, unless the crawl is cancelled: the link then
+// stays in the frontier for the final checkpoint. The links that fail permanently are recorded
+// and dispatched to the wrapped Handler like any other response.
+func (r *Runner) retryHandler(wrapped fetchbot.Handler) fetchbot.Handler {
+	return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+		c, ok := ctx.Cmd.(*linkCmd)
+		p := r.RetryPolicy
+		if !ok || err == fetchbot.ErrDisallowed || err == ErrSkipped || err == ErrCancelled {
+			wrapped.Handle(ctx, res, err)
+			return
+		}
+
+		retryable := p != nil && p.retryable(res, err)
+		attempts := c.attempt + 1
+		if retryable && attempts < p.MaxAttempts {
+			delay := p.Backoff(attempts)
+			if err == nil {
+				// a Retry-After header asks for a longer delay
+				if after, ok := retryAfter(res.Header.Get("Retry-After"), time.Now()); ok && after > delay {
+					delay = after
+				}
+			}
+			if p.MaxDelay > 0 && delay > p.MaxDelay {
+				delay = p.MaxDelay
+			}
+			status := 0
+			if err == nil {
+				status = res.StatusCode
+			}
+			f := cmdFields(c, status, err)
+			f["attempt"] = attempts
+			f["retries"] = p.MaxAttempts - 1
+			f["delay"] = delay
+			r.log().Warn("retry", f)
+			if !r.sleep(delay) {
+				c.dropped = true
+				return
+			}
+
+			next := c.again()
+			next.attempt = attempts
+			if err := r.send(ctx.Q, next); err == nil {
+				return
+			}
+			// the queue is closed, the link can't be retried
+		}
+
+		r.fetched(c)
+		if err != nil || retryable || res.StatusCode >= 500 || res.StatusCode >= 400 && c.Method() == "GET" {
+			status := 0
+			if res != nil {
+				status = res.StatusCode
+			}
+			r.fail(c, StageFetch, failureType(err), status, err)
+		}
+		wrapped.Handle(ctx, res, err)
+	})
+}
@@ -0,0 +1,395 @@
+package hermes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+	"golang.org/x/net/context"
+)
+
+// maxRevisitMisses is the number of consecutive revisits without a Document after which a URL is
+// dropped from the history (i.e. it now answers 404 or isn't html anymore).
+const maxRevisitMisses = 3
+
+type (
+	// A Revisitor recrawls a site forever. It runs a full crawl with its Runner to discover the
+	// pages, then revisits every page on its own schedule: the interval of a page is halved when
+	// its content changed since the last visit and doubled when it did not, between the
+	// MinInterval and the MaxInterval. The history of every page is saved to a file so that the
+	// schedule survives a restart. Unlike the Runner's fields, the durations of a Revisitor are
+	// real durations (i.e. 6 * time.Hour).
+	Revisitor struct {
+		// The Runner crawls the site. Its DocumentHandler receives the Documents of every visit, the
+		// unchanged ones included. A PageStore on the Runner makes the revisits of unchanged pages cheaper.
+		Runner *Runner
+
+		// The HistoryPath is the file the history of the pages is saved to after every crawl.
+		HistoryPath string
+
+		// The MinInterval and MaxInterval bound the interval between two visits of a page.
+		MinInterval time.Duration
+		MaxInterval time.Duration
+
+		// The DiscoverInterval is the set time between two full crawls, which find the new pages of the site.
+		DiscoverInterval time.Duration
+
+		// The Budget is the maximum number of pages fetched per BudgetPeriod. The pages that are the most
+		// overdue are revisited first when the budget is tight. Set it to 0 for no limit.
+		Budget       int
+		BudgetPeriod time.Duration
+
+		mu    sync.Mutex
+		state revisitState
+	}
+
+	// A RevisitHistory is the history of a page revisited by a Revisitor.
+	RevisitHistory struct {
+		URL       string        `json:"url"`
+		Hash      string        `json:"hash"`
+		Interval  time.Duration `json:"interval"`
+		LastVisit time.Time     `json:"last_visit"`
+		NextVisit time.Time     `json:"next_visit"`
+		Visits    int           `json:"visits"`
+		Changes   int           `json:"changes"`
+		Misses    int           `json:"misses,omitempty"`
+	}
+
+	// revisitState is the state of a Revisitor saved to its HistoryPath.
+	revisitState struct {
+		Pages      map[string]*RevisitHistory `json:"pages"`
+		Discovered time.Time                  `json:"discovered"`
+		Window     time.Time                  `json:"window"`
+		Fetches    int                        `json:"fetches"`
+	}
+)
+
+// NewRevisitor returns a Revisitor that revisits the pages of the Runner between hourly and
+// weekly, with a full crawl every week. These values can be overwritten after initializing the
+// new Revisitor reference.
+func NewRevisitor(r *Runner, historyPath string) *Revisitor {
+	return &Revisitor{
+		Runner:           r,
+		HistoryPath:      historyPath,
+		MinInterval:      time.Hour,
+		MaxInterval:      7 * 24 * time.Hour,
+		DiscoverInterval: 7 * 24 * time.Hour,
+		BudgetPeriod:     24 * time.Hour,
+	}
+}
+
+// Run revisits the site until the context is done, and returns the context's error. The history
+// saved in the HistoryPath is loaded first, so a restarted Revisitor keeps its schedule.
+func (v *Revisitor) Run(ctx context.Context) error {
+	if err := v.load(); err != nil {
+		return err
+	}
+
+	for {
+		now := time.Now()
+		budget := v.budget(now)
+
+		var err error
+		switch {
+		case budget == 0:
+			// wait for the next budget period
+		case now.Sub(v.state.Discovered) >= v.DiscoverInterval || len(v.state.Pages) == 0:
+			err = v.discover(ctx, budget)
+		default:
+			if due := v.due(now, budget); len(due) > 0 {
+				err = v.revisit(ctx, due)
+			}
+		}
+		if ctx.Err() != nil {
+			v.save()
+			return ctx.Err()
+		}
+		if err != nil {
+			v.Runner.log().Error("revisit failed", requestFields("", v.Runner.URL, 0, err))
+		}
+		v.save()
+
+		select {
+		case <-time.After(v.wait(time.Now())):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// History returns the history of the pages known to the Revisitor.
+func (v *Revisitor) History() []RevisitHistory {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h := make([]RevisitHistory, 0, len(v.state.Pages))
+	for _, p := range v.state.Pages {
+		h = append(h, *p)
+	}
+	sort.Slice(h, func(i, j int) bool { return h[i].NextVisit.Before(h[j].NextVisit) })
+	return h
+}
+
+// discover runs a full crawl of the site, which finds the new pages and visits the known ones.
+func (v *Revisitor) discover(ctx context.Context, budget int) error {
+	r := v.Runner
+	defer func(n int) { r.MaximumDocuments = n }(r.MaximumDocuments)
+	if budget > 0 && (r.MaximumDocuments <= 0 || budget < r.MaximumDocuments) {
+		r.MaximumDocuments = budget
+	}
+
+	// a failed discovery is tried again after the MinInterval
+	_, err := v.crawl(ctx)
+	if err == nil {
+		v.mu.Lock()
+		v.state.Discovered = time.Now()
+		v.mu.Unlock()
+	}
+	return err
+}
+
+// revisit fetches the due pages, without following their links. The due pages are already
+// limited by the budget, so the MaximumDocuments of the Runner doesn't apply.
+func (v *Revisitor) revisit(ctx context.Context, due []*url.URL) error {
+	r := v.Runner
+	defer func(n int) {
+		r.seeds = nil
+		r.MaximumDocuments = n
+	}(r.MaximumDocuments)
+	r.seeds = due
+	r.MaximumDocuments = 0
+
+	start := time.Now()
+	fetched, err := v.crawl(ctx)
+	if ctx.Err() != nil || err == ErrInterrupted {
+		// the pages the interrupted crawl didn't get to are revisited next time
+		return err
+	}
+
+	// the pages that were fetched without producing a Document are tried again later, then dropped
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, u := range due {
+		p, ok := v.state.Pages[u.String()]
+		if !ok || !p.LastVisit.Before(start) || !fetched[u.String()] {
+			continue
+		}
+		p.Misses++
+		if p.Misses >= maxRevisitMisses {
+			delete(v.state.Pages, u.String())
+			continue
+		}
+		p.NextVisit = start.Add(p.Interval)
+	}
+	return err
+}
+
+// crawl runs the Runner with a DocumentHandler that updates the history of the pages before
+// handing the Documents over to the Runner's own DocumentHandler. It returns the URLs that got a
+// response.
+func (v *Revisitor) crawl(ctx context.Context) (map[string]bool, error) {
+	r := v.Runner
+	handler := r.DocumentHandler
+	middleware := r.Middleware
+	defer func() {
+		r.DocumentHandler = handler
+		r.Middleware = middleware
+	}()
+
+	var mu sync.Mutex
+	fetched := make(map[string]bool)
+	r.Middleware = append([]Middleware{func(h fetchbot.Handler) fetchbot.Handler {
+		return fetchbot.HandlerFunc(func(ctx *fetchbot.Context, res *http.Response, err error) {
+			if err == nil && ctx.Cmd.Method() == "GET" {
+				mu.Lock()
+				fetched[ctx.Cmd.URL().String()] = true
+				mu.Unlock()
+			}
+			h.Handle(ctx, res, err)
+		})
+	}}, middleware...)
+
+	r.DocumentHandler = DocumentHandlerFunc(func(d Document) error {
+		v.visit(d, time.Now())
+		if handler != nil {
+			return handler.HandleDocument(d)
+		}
+		return nil
+	})
+	_, err := r.CrawlContext(ctx)
+	return fetched, err
+}
+
+// visit updates the history of the page of a Document and schedules its next visit.
+func (v *Revisitor) visit(d Document, now time.Time) {
+	hash := contentHash(d)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.state.Fetches++
+	p, ok := v.state.Pages[d.Link]
+	switch {
+	case !ok:
+		p = &RevisitHistory{URL: d.Link, Interval: v.clamp(24 * time.Hour)}
+		if !d.Unchanged {
+			p.Hash = hash
+		}
+		v.state.Pages[d.Link] = p
+	case p.Hash == "" && !d.Unchanged:
+		// first time the content is downloaded
+		p.Hash = hash
+	case !d.Unchanged && hash != p.Hash:
+		p.Hash = hash
+		p.Changes++
+		p.Interval = v.clamp(p.Interval / 2)
+	default:
+		p.Interval = v.clamp(p.Interval * 2)
+	}
+	p.Visits++
+	p.Misses = 0
+	p.LastVisit = now
+	p.NextVisit = now.Add(p.Interval)
+}
+
+// due returns the pages to revisit now, at most n if n > 0, the most overdue ones first
+// relative to their interval.
+func (v *Revisitor) due(now time.Time, n int) []*url.URL {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var pages []*RevisitHistory
+	for _, p := range v.state.Pages {
+		if !p.NextVisit.After(now) {
+			pages = append(pages, p)
+		}
+	}
+	overdue := func(p *RevisitHistory) float64 {
+		return float64(now.Sub(p.NextVisit)) / float64(p.Interval)
+	}
+	sort.Slice(pages, func(i, j int) bool { return overdue(pages[i]) > overdue(pages[j]) })
+	if n > 0 && len(pages) > n {
+		pages = pages[:n]
+	}
+
+	var due []*url.URL
+	for _, p := range pages {
+		u, err := url.Parse(p.URL)
+		if err != nil {
+			delete(v.state.Pages, p.URL)
+			continue
+		}
+		due = append(due, u)
+	}
+	return due
+}
+
+// budget returns the number of pages that can still be fetched in the current budget period,
+// -1 if there is no limit.
+func (v *Revisitor) budget(now time.Time) int {
+	if v.Budget <= 0 {
+		return -1
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.state.Window) >= v.BudgetPeriod {
+		v.state.Window = now
+		v.state.Fetches = 0
+	}
+	if v.state.Fetches >= v.Budget {
+		return 0
+	}
+	return v.Budget - v.state.Fetches
+}
+
+// wait returns the time to wait for the next page to revisit, the next full crawl or the next
+// budget period, whichever comes first. It never waits longer than the MinInterval.
+func (v *Revisitor) wait(now time.Time) time.Duration {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	next := v.state.Discovered.Add(v.DiscoverInterval)
+	if v.Budget > 0 && v.state.Fetches >= v.Budget {
+		// nothing can be fetched before the next budget period
+		next = v.state.Window.Add(v.BudgetPeriod)
+	} else {
+		for _, p := range v.state.Pages {
+			if p.NextVisit.Before(next) {
+				next = p.NextVisit
+			}
+		}
+	}
+	d := next.Sub(now)
+	if d > v.MinInterval {
+		d = v.MinInterval
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// clamp bounds an interval between the MinInterval and the MaxInterval.
+func (v *Revisitor) clamp(d time.Duration) time.Duration {
+	if d < v.MinInterval {
+		d = v.MinInterval
+	}
+	if v.MaxInterval > 0 && d > v.MaxInterval {
+		d = v.MaxInterval
+	}
+	return d
+}
+
+// load reads the history saved in the HistoryPath, if any.
+func (v *Revisitor) load() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.state = revisitState{Pages: make(map[string]*RevisitHistory)}
+	if v.HistoryPath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(v.HistoryPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &v.state); err != nil {
+		return err
+	}
+	if v.state.Pages == nil {
+		v.state.Pages = make(map[string]*RevisitHistory)
+	}
+	return nil
+}
+
+// save writes the history to the HistoryPath. The file is replaced atomically, like a Checkpoint.
+func (v *Revisitor) save() {
+	if v.HistoryPath == "" {
+		return
+	}
+	v.mu.Lock()
+	data, err := json.Marshal(v.state)
+	v.mu.Unlock()
+	if err == nil {
+		err = writeFileAtomic(v.HistoryPath, data)
+	}
+	if err != nil {
+		v.Runner.log().Error("saving revisit history failed", Fields{"path": v.HistoryPath, "error": err})
+	}
+}
+
+// contentHash returns the hash of the scraped content of a Document.
+func contentHash(d Document) string {
+	h := sha256.New()
+	for _, s := range []string{d.Title, d.Description, d.Content} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,176 @@
+package hermes
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// RobotsDirectives are the page-level robots directives of a page, set by <meta name="robots">
+// tags and X-Robots-Tag headers.
+type RobotsDirectives struct {
+	// NoIndex asks not to index the page.
+	NoIndex bool
+	// NoFollow asks not to follow the links of the page.
+	NoFollow bool
+	// NoArchive asks not to keep a copy of the content of the page.
+	NoArchive bool
+	// UnavailableAfter is the time after which the page must not be indexed, zero if not set.
+	UnavailableAfter time.Time
+}
+
+// Expired returns true if the page is past its unavailable_after date.
+func (d RobotsDirectives) Expired(now time.Time) bool {
+	return !d.UnavailableAfter.IsZero() && now.After(d.UnavailableAfter)
+}
+
+// robotsDirectives collects the directives that apply to the user agent from the X-Robots-Tag
+// headers of the response and the robots meta tags of the document. Both the generic ones
+// ("robots" or no user agent) and the ones naming the user agent apply.
+func robotsDirectives(res *http.Response, doc *goquery.Document, userAgent string) RobotsDirectives {
+	var d RobotsDirectives
+	agent := agentToken(userAgent)
+
+	if res != nil {
+		for _, v := range res.Header[http.CanonicalHeaderKey("X-Robots-Tag")] {
+			// a value may be prefixed by the user agent it applies to (i.e. "googlebot: noindex"), a
+			// single token unlike "noindex, max-snippet: 50"
+			if i := strings.Index(v, ":"); i > 0 {
+				prefix := strings.ToLower(strings.TrimSpace(v[:i]))
+				if !strings.ContainsAny(prefix, ", \t") && !isRobotsDirective(prefix) {
+					if prefix != agent {
+						continue
+					}
+					v = v[i+1:]
+				}
+			}
+			d.parse(v)
+		}
+	}
+
+	if doc != nil {
+		doc.Find("meta[name][content]").Each(func(i int, s *goquery.Selection) {
+			name, _ := s.Attr("name")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "robots" || (agent != "" && name == agent) {
+				content, _ := s.Attr("content")
+				d.parse(content)
+			}
+		})
+	}
+	return d
+}
+
+// parse adds the comma or space separated directives of a meta tag content or header value.
+func (d *RobotsDirectives) parse(v string) {
+	for _, part := range splitDirectives(v) {
+		for _, directive := range splitWords(part) {
+			lower := strings.ToLower(directive)
+			switch {
+			case lower == "noindex":
+				d.NoIndex = true
+			case lower == "nofollow":
+				d.NoFollow = true
+			case lower == "noarchive":
+				d.NoArchive = true
+			case lower == "none":
+				d.NoIndex, d.NoFollow = true, true
+			case strings.HasPrefix(lower, "unavailable_after:"):
+				if t := parseUnavailableAfter(directive[len("unavailable_after:"):]); !t.IsZero() {
+					d.UnavailableAfter = t
+				}
+			}
+		}
+	}
+}
+
+// splitDirectives splits a list of directives on its commas, except for the commas of the date
+// of an unavailable_after directive (i.e. "unavailable_after: Monday, 02-Jan-06 15:04:05 MST").
+func splitDirectives(v string) []string {
+	var directives []string
+	for _, part := range strings.Split(v, ",") {
+		if n := len(directives); n > 0 && !startsDirective(part) &&
+			strings.Contains(strings.ToLower(directives[n-1]), "unavailable_after") {
+			directives[n-1] += "," + part
+			continue
+		}
+		directives = append(directives, part)
+	}
+	return directives
+}
+
+// splitWords splits a directive on its spaces, for the lists separated by spaces (i.e.
+// "noindex nofollow"). The value after a colon stays with its directive, without the spaces
+// before the colon.
+func splitWords(s string) []string {
+	value := ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		s, value = s[:i], s[i:]
+	}
+	words := strings.Fields(s)
+	if n := len(words); n > 0 {
+		words[n-1] += value
+	}
+	return words
+}
+
+// startsDirective returns true if the text starts with a robots directive.
+func startsDirective(s string) bool {
+	name := strings.ToLower(s)
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	if words := strings.Fields(name); len(words) > 0 {
+		name = words[0]
+	}
+	switch name {
+	case "all", "noindex", "nofollow", "noarchive", "none", "nosnippet", "notranslate", "noimageindex", "indexifembedded", "nocache":
+		return true
+	}
+	return isRobotsDirective(name)
+}
+
+// isRobotsDirective returns true if the name is a directive that takes a value, as opposed to a
+// user agent prefix.
+func isRobotsDirective(name string) bool {
+	switch name {
+	case "unavailable_after", "max-snippet", "max-image-preview", "max-video-preview":
+		return true
+	}
+	return false
+}
+
+// agentToken returns the lowercased first word of a user agent string (i.e. "hermes" for
+// "Hermes Bot (github.com/jtaylor32/hermes").
+func agentToken(userAgent string) string {
+	fields := strings.Fields(userAgent)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.Split(fields[0], "/")[0])
+}
+
+// parseUnavailableAfter parses the date of an unavailable_after directive, which is commonly
+// set in RFC 850, RFC 1123 or ISO 8601 formats. It returns the zero time if it can't be parsed.
+func parseUnavailableAfter(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC850, time.RFC1123, time.RFC1123Z, "2 Jan 2006 15:04:05 MST", "02-Jan-2006 15:04:05 MST", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return parseW3CTime(s)
+}
+
+// hasNoFollow returns true if the rel attribute of a link contains "nofollow".
+func hasNoFollow(s *goquery.Selection) bool {
+	rel, _ := s.Attr("rel")
+	for _, v := range strings.Fields(rel) {
+		if strings.EqualFold(v, "nofollow") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package hermes
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestRobotsDirectives(t *testing.T) {
+	june25 := time.Date(2010, 6, 25, 15, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		headers []string
+		meta    string
+		want    RobotsDirectives
+	}{
+		// lists separated by commas or spaces
+		{[]string{"noindex"}, "", RobotsDirectives{NoIndex: true}},
+		{[]string{"noindex, nofollow"}, "", RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{[]string{"noindex nofollow"}, "", RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{[]string{"NOINDEX,NOARCHIVE"}, "", RobotsDirectives{NoIndex: true, NoArchive: true}},
+		{[]string{"none"}, "", RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{[]string{"all, max-snippet: 50"}, "", RobotsDirectives{}},
+		{[]string{"noindex, max-snippet: 50"}, "", RobotsDirectives{NoIndex: true}},
+		{[]string{"max-image-preview: large nofollow"}, "", RobotsDirectives{}},
+
+		// agent-scoped directives
+		{[]string{"googlebot: noindex"}, "", RobotsDirectives{}},
+		{[]string{"hermes: noindex nofollow"}, "", RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{[]string{"HERMES: noarchive"}, "", RobotsDirectives{NoArchive: true}},
+		{[]string{"googlebot: noindex", "nofollow"}, "", RobotsDirectives{NoFollow: true}},
+		{[]string{"googlebot, bingbot: noindex"}, "", RobotsDirectives{}},
+
+		// unavailable_after dates, their commas are not separators
+		{[]string{"unavailable_after: Friday, 25-Jun-10 15:00:00 UTC"}, "", RobotsDirectives{UnavailableAfter: june25}},
+		{[]string{"unavailable_after: Fri, 25 Jun 2010 15:00:00 UTC, noindex"}, "", RobotsDirectives{NoIndex: true, UnavailableAfter: june25}},
+		{[]string{"unavailable_after: Fri, 25 Jun 2010 15:00:00 +0000"}, "", RobotsDirectives{UnavailableAfter: june25}},
+		{[]string{"unavailable_after: 25 Jun 2010 15:00:00 UTC"}, "", RobotsDirectives{UnavailableAfter: june25}},
+		{[]string{"unavailable_after: 25-Jun-2010 15:00:00 UTC"}, "", RobotsDirectives{UnavailableAfter: june25}},
+		{[]string{"unavailable_after: 2010-06-25T15:00:00Z"}, "", RobotsDirectives{UnavailableAfter: june25}},
+		{[]string{"unavailable_after: 2010-06-25"}, "", RobotsDirectives{UnavailableAfter: time.Date(2010, 6, 25, 0, 0, 0, 0, time.UTC)}},
+		{[]string{"noindex unavailable_after: Fri, 25 Jun 2010 15:00:00 UTC, nofollow noarchive"}, "", RobotsDirectives{NoIndex: true, NoFollow: true, NoArchive: true, UnavailableAfter: june25}},
+		{[]string{"hermes: unavailable_after: 2010-06-25T15:00:00Z"}, "", RobotsDirectives{UnavailableAfter: june25}},
+		{[]string{"unavailable_after: someday, noindex"}, "", RobotsDirectives{NoIndex: true}},
+
+		// meta tags, combined with the headers
+		{nil, `<meta name="robots" content="noindex, nofollow">`, RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{nil, `<meta name="ROBOTS" content="noarchive">`, RobotsDirectives{NoArchive: true}},
+		{nil, `<meta name="hermes" content="noindex">`, RobotsDirectives{NoIndex: true}},
+		{nil, `<meta name="googlebot" content="noindex">`, RobotsDirectives{}},
+		{nil, `<meta name="description" content="noindex">`, RobotsDirectives{}},
+		{[]string{"nofollow"}, `<meta name="robots" content="noindex">`, RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{[]string{"googlebot: nofollow"}, `<meta name="robots" content="unavailable_after: 2010-06-25T15:00:00Z">`, RobotsDirectives{UnavailableAfter: june25}},
+	} {
+		res := &http.Response{Header: http.Header{}}
+		for _, v := range c.headers {
+			res.Header.Add("X-Robots-Tag", v)
+		}
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<html><head>" + c.meta + "</head><body></body></html>"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := robotsDirectives(res, doc, "Hermes/1.0 (+https://github.com/jtaylor32/hermes)")
+		if got.NoIndex != c.want.NoIndex || got.NoFollow != c.want.NoFollow || got.NoArchive != c.want.NoArchive ||
+			!got.UnavailableAfter.Equal(c.want.UnavailableAfter) {
+			t.Errorf("headers %q, meta %q: got %+v, want %+v", c.headers, c.meta, got, c.want)
+		}
+	}
+}
+
+func TestRobotsDirectivesExpired(t *testing.T) {
+	d := RobotsDirectives{UnavailableAfter: time.Date(2010, 6, 25, 15, 0, 0, 0, time.UTC)}
+	if d.Expired(time.Date(2010, 6, 25, 14, 0, 0, 0, time.UTC)) {
+		t.Error("Expired before the date")
+	}
+	if !d.Expired(time.Date(2010, 6, 25, 16, 0, 0, 0, time.UTC)) {
+		t.Error("not Expired after the date")
+	}
+	if (RobotsDirectives{}).Expired(time.Now()) {
+		t.Error("Expired without a date")
+	}
+}
+
+func TestAgentToken(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Hermes/1.0 (+https://github.com/jtaylor32/hermes)": "hermes",
+		"Googlebot":        "googlebot",
+		"  my-crawler 2.0": "my-crawler",
+		"":                 "",
+	} {
+		if got := agentToken(ua); got != want {
+			t.Errorf("agentToken(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
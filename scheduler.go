@@ -0,0 +1,325 @@
+package hermes
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrUnknownJob defines a job name that is not one of the Scheduler's jobs
+	ErrUnknownJob = errors.New("unknown job")
+	// ErrJobRunning defines a job that can't be started because its previous run is not done
+	ErrJobRunning = errors.New("job is already running")
+)
+
+// defaultRunHistory is the number of runs a Scheduler keeps in its history by default.
+const defaultRunHistory = 1000
+
+type (
+	// A Scheduler runs the crawls of the Sources entries on their cron schedule: each run crawls
+	// the entry's link with a Runner configured from the Settings, then stores the Documents in
+	// the Elasticsearch index of the Settings. A job never runs twice at the same time, a run
+	// that is due while the previous one is not done is skipped. Every run is recorded in the
+	// history with its duration and outcome.
+	Scheduler struct {
+		// The Settings configure the Runners and the Elasticsearch store of every job.
+		Settings Settings
+
+		// The Store stores the Documents of a run. If it is nil they are stored in the Elasticsearch index of the
+		// Settings.
+		Store func(job string, docs []Document) error
+
+		// The Configure function is called with the Runner of every run before it starts, to set the Runner fields
+		// that the Settings don't have.
+		Configure func(job string, r *Runner)
+
+		// The HistoryPath is the file the run history is saved to after every run. Leave it empty to keep the
+		// history in memory only.
+		HistoryPath string
+
+		// The HistorySize is the number of runs kept in the history, the oldest ones are dropped first.
+		HistorySize int
+
+		// The Logger receives the log entries of the Scheduler. It is also the Logger of the Runners and of the
+		// Elasticsearch store of the runs, unless Configure sets another one. If it is nil nothing is logged.
+		Logger Logger
+
+		// The Metrics collects the metrics of the Runners and of the Elasticsearch store of the runs.
+		Metrics *Metrics
+
+		// The TracerProvider traces the crawls of the Runners and the bulk inserts of the Elasticsearch store of the runs.
+		TracerProvider trace.TracerProvider
+
+		mu      sync.Mutex
+		jobs    map[string]*job
+		history []JobRun
+		wg      sync.WaitGroup
+	}
+
+	// A JobRun is a run of a Scheduler's job.
+	JobRun struct {
+		Job       string        `json:"job"`
+		Manual    bool          `json:"manual,omitempty"`
+		Skipped   bool          `json:"skipped,omitempty"`
+		Start     time.Time     `json:"start"`
+		End       time.Time     `json:"end"`
+		Duration  time.Duration `json:"duration"`
+		Documents int           `json:"documents"`
+		Failures  int           `json:"failures,omitempty"`
+		Error     string        `json:"error,omitempty"`
+	}
+
+	// job is a Sources entry with its parsed schedule, nil if it only runs when triggered.
+	job struct {
+		settings CustomSettings
+		schedule *CronSchedule
+		next     time.Time
+		running  bool
+	}
+)
+
+// NewScheduler returns a Scheduler for the Sources entries. The jobs are named after the links
+// of the entries. It returns an error if a schedule is not a valid cron expression (see
+// ParseCron). The entries without a schedule only run when triggered with RunNow.
+func NewScheduler(settings Settings, sources Sources) (*Scheduler, error) {
+	s := &Scheduler{
+		Settings:    settings,
+		HistorySize: defaultRunHistory,
+		jobs:        make(map[string]*job),
+	}
+	for _, cs := range sources.Links {
+		j := &job{settings: cs}
+		if cs.Schedule != "" {
+			schedule, err := ParseCron(cs.Schedule)
+			if err != nil {
+				return nil, err
+			}
+			j.schedule = schedule
+		}
+		s.jobs[cs.RootLink] = j
+	}
+	return s, nil
+}
+
+// Jobs returns the names of the Scheduler's jobs.
+func (s *Scheduler) Jobs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run runs the jobs on their schedules until the context is done. The running jobs are
+// cancelled and waited for, then the context's error is returned. The history saved in the
+// HistoryPath is loaded first.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for {
+		now := time.Now()
+		s.mu.Lock()
+		next := time.Time{}
+		for name, j := range s.jobs {
+			if j.schedule == nil {
+				continue
+			}
+			if j.next.IsZero() {
+				j.next = j.schedule.Next(now)
+			}
+			if j.next.IsZero() {
+				// the schedule doesn't match anytime soon
+				continue
+			}
+			if !j.next.After(now) {
+				s.start(ctx, name, j, false)
+				j.next = j.schedule.Next(now)
+			}
+			if !j.next.IsZero() && (next.IsZero() || j.next.Before(next)) {
+				next = j.next
+			}
+		}
+		s.mu.Unlock()
+
+		wait := time.Minute
+		if !next.IsZero() && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			s.wg.Wait()
+			return ctx.Err()
+		}
+	}
+}
+
+// RunNow starts a run of the job right away, in the background. It returns ErrUnknownJob if
+// there is no such job and ErrJobRunning if the job is already running. The run is bound to
+// the context.
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrUnknownJob
+	}
+	if j.running {
+		return ErrJobRunning
+	}
+	s.start(ctx, name, j, true)
+	return nil
+}
+
+// Wait blocks until the runs in progress are done.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// History returns the runs of the jobs, the most recent last.
+func (s *Scheduler) History() []JobRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]JobRun(nil), s.history...)
+}
+
+// start runs the job in a new goroutine, or records a skipped run if it is still running. The
+// lock must be held.
+func (s *Scheduler) start(ctx context.Context, name string, j *job, manual bool) {
+	if j.running {
+		logger(s.Logger).Warn("run skipped, job still running", Fields{"job": name})
+		now := time.Now()
+		s.record(JobRun{Job: name, Manual: manual, Skipped: true, Start: now, End: now})
+		return
+	}
+	j.running = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		run := s.run(ctx, name, j.settings)
+		run.Manual = manual
+
+		s.mu.Lock()
+		j.running = false
+		s.record(run)
+		s.mu.Unlock()
+		s.save()
+	}()
+}
+
+// run crawls the link of a job and stores its Documents.
+func (s *Scheduler) run(ctx context.Context, name string, cs CustomSettings) JobRun {
+	run := JobRun{Job: name, Start: time.Now()}
+	logger(s.Logger).Info("job started", Fields{"job": name})
+
+	n, failures, err := s.pipeline(ctx, name, cs)
+	run.End = time.Now()
+	run.Duration = run.End.Sub(run.Start)
+	run.Documents = n
+	run.Failures = failures
+	if err != nil {
+		run.Error = err.Error()
+		logger(s.Logger).Error("job failed", Fields{"job": name, "documents": n, "duration": run.Duration, "error": err})
+	} else {
+		logger(s.Logger).Info("job done", Fields{"job": name, "documents": n, "duration": run.Duration})
+	}
+	return run
+}
+
+// pipeline runs the Runner of a job and stores the Documents it scraped. It returns the number
+// of Documents and of failed links.
+func (s *Scheduler) pipeline(ctx context.Context, name string, cs CustomSettings) (int, int, error) {
+	u, err := url.Parse(cs.RootLink)
+	if err != nil {
+		return 0, 0, err
+	}
+	r := s.Settings.Runner()
+	r.URL = u
+	r.Tags = cs.Tags
+	r.Subdomain = cs.Subdomain
+	r.TopLevelDomain = cs.TopLevelDomain
+	r.Logger = s.Logger
+	r.Metrics = s.Metrics
+	r.TracerProvider = s.TracerProvider
+	if s.Configure != nil {
+		s.Configure(name, r)
+	}
+
+	docs, err := r.CrawlContext(ctx)
+	failures := 0
+	if res := r.Result(); res != nil {
+		failures = len(res.Failures)
+	}
+	if err != nil {
+		return len(docs), failures, err
+	}
+	if len(docs) == 0 {
+		return 0, failures, nil
+	}
+
+	if s.Store != nil {
+		return len(docs), failures, s.Store(name, docs)
+	}
+	es := Elasticsearch{Host: s.Settings.ElasticsearchHost, Index: s.Settings.ElasticsearchIndex, Type: s.Settings.ElasticsearchType, Logger: s.Logger, Metrics: s.Metrics, TracerProvider: s.TracerProvider}
+	return len(docs), failures, es.Store(len(docs), docs)
+}
+
+// record adds a run to the history. The lock must be held.
+func (s *Scheduler) record(run JobRun) {
+	s.history = append(s.history, run)
+	if s.HistorySize > 0 && len(s.history) > s.HistorySize {
+		s.history = append([]JobRun(nil), s.history[len(s.history)-s.HistorySize:]...)
+	}
+}
+
+// load reads the history saved in the HistoryPath, if any.
+func (s *Scheduler) load() error {
+	if s.HistoryPath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.HistoryPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var history []JobRun
+	if err := json.Unmarshal(data, &history); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append(history, s.history...)
+	return nil
+}
+
+// save writes the history to the HistoryPath.
+func (s *Scheduler) save() {
+	if s.HistoryPath == "" {
+		return
+	}
+	s.mu.Lock()
+	data, err := json.Marshal(s.history)
+	s.mu.Unlock()
+	if err == nil {
+		err = writeFileAtomic(s.HistoryPath, data)
+	}
+	if err != nil {
+		logger(s.Logger).Error("saving job history failed", Fields{"path": s.HistoryPath, "error": err})
+	}
+}
@@ -0,0 +1,225 @@
+package hermes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+var (
+	// ErrOutOfDomain defines a link outside of the Runner's domain scope (see TopLevelDomain and Subdomain)
+	ErrOutOfDomain = errors.New("out of domain scope")
+	// ErrDenied defines a link matching one of the Scope's Deny rules
+	ErrDenied = errors.New("denied by rule")
+	// ErrNotAllowed defines a link matching none of the Scope's Allow rules
+	ErrNotAllowed = errors.New("not allowed by any rule")
+	// ErrOutOfPath defines a link outside of the Scope's PathPrefixes
+	ErrOutOfPath = errors.New("out of path prefixes")
+	// ErrMaxDepth defines a link deeper than the Scope's MaxDepth
+	ErrMaxDepth = errors.New("maximum depth reached")
+)
+
+// A ScopeError explains why a URL was rejected by the Runner's scope. Err is one of the scope
+// sentinel errors (ErrOutOfDomain, ErrDenied, etc.) and Rule the rule that rejected it, if any.
+type ScopeError struct {
+	URL  string
+	Err  error
+	Rule string
+}
+
+func (e *ScopeError) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.URL, e.Err, e.Rule)
+	}
+	return fmt.Sprintf("%s: %s", e.URL, e.Err)
+}
+
+// A Rule matches URLs with a regular expression or a glob pattern.
+type Rule struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// RegexpRule returns a Rule matching the URLs that contain a match of the regular expression expr.
+func RegexpRule(expr string) (Rule, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{pattern: expr, re: re}, nil
+}
+
+// GlobRule returns a Rule matching the whole URL against a glob pattern, where '*' matches any
+// sequence of characters and '?' matches a single character (i.e. "*://*.example.com/docs/*").
+func GlobRule(pattern string) (Rule, error) {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{pattern: pattern, re: re}, nil
+}
+
+// Match returns true if the Rule matches u.
+func (r Rule) Match(u *url.URL) bool {
+	return r.re != nil && r.re.MatchString(u.String())
+}
+
+// String returns the pattern of the Rule.
+func (r Rule) String() string {
+	return r.pattern
+}
+
+// A Scope defines the rules a link must pass to be crawled by a Runner, on top of the Runner's
+// TopLevelDomain and Subdomain toggles. The zero value doesn't restrict anything.
+type Scope struct {
+	// The Allow rules are the rules a link must match at least one of. If empty all links are allowed.
+	Allow []Rule
+
+	// The Deny rules are the rules a link must not match. They have priority over the Allow rules.
+	Deny []Rule
+
+	// The PathPrefixes restrict the links to the paths starting with one of the prefixes (i.e. /docs/).
+	// If empty all paths are allowed.
+	PathPrefixes []string
+
+	// The MaxDepth is the maximum number of links followed from the seed URL. Set it to 0 for no limit.
+	MaxDepth int
+
+	// The ExternalHops is the number of consecutive links that may be followed outside of the domain scope.
+	// Set it to 0 to stay within the domain.
+	ExternalHops int
+}
+
+// Check returns nil if the link u is in scope, or a *ScopeError explaining why it is rejected.
+// The depth is the number of links followed from the seed to reach u, and hops the number of
+// consecutive links followed outside of the domain scope.
+func (s *Scope) Check(u *url.URL, depth, hops int) error {
+	for _, rule := range s.Deny {
+		if rule.Match(u) {
+			return &ScopeError{URL: u.String(), Err: ErrDenied, Rule: rule.String()}
+		}
+	}
+
+	if len(s.Allow) > 0 {
+		allowed := false
+		for _, rule := range s.Allow {
+			if rule.Match(u) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &ScopeError{URL: u.String(), Err: ErrNotAllowed}
+		}
+	}
+
+	if len(s.PathPrefixes) > 0 {
+		allowed := false
+		for _, prefix := range s.PathPrefixes {
+			if strings.HasPrefix(u.Path, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &ScopeError{URL: u.String(), Err: ErrOutOfPath, Rule: strings.Join(s.PathPrefixes, ", ")}
+		}
+	}
+
+	if s.MaxDepth > 0 && depth > s.MaxDepth {
+		return &ScopeError{URL: u.String(), Err: ErrMaxDepth, Rule: fmt.Sprintf("max depth %d", s.MaxDepth)}
+	}
+
+	if hops > s.ExternalHops {
+		return &ScopeError{URL: u.String(), Err: ErrOutOfDomain, Rule: fmt.Sprintf("external hops %d", s.ExternalHops)}
+	}
+	return nil
+}
+
+// CheckScope returns nil if the Runner would crawl the link u found at the given depth from the
+// seed on a page within the domain scope, or a *ScopeError explaining why it is rejected.
+func (r *Runner) CheckScope(u *url.URL, depth int) error {
+	_, err := r.checkScope(u, depth, 0)
+	return err
+}
+
+// checkScope checks the link u discovered at depth on a page that was itself parentHops links
+// outside of the domain scope. It returns the external hops of u.
+func (r *Runner) checkScope(u *url.URL, depth, parentHops int) (int, error) {
+	hops := 0
+	if !r.sameDomain(r.URL.Host, u.Host) {
+		hops = parentHops + 1
+	}
+
+	s := r.Scope
+	if s == nil {
+		s = &Scope{}
+	}
+	return hops, s.Check(u, depth, hops)
+}
+
+// A linkCmd is a fetchbot Command that remembers how its link was discovered.
+type linkCmd struct {
+	*fetchbot.Cmd
+
+	// depth is the number of links followed from the seed
+	depth int
+	// hops is the number of consecutive links followed outside of the domain scope
+	hops int
+	// sitemap holds the sitemap hints of the link, if it was found in a sitemap
+	sitemap *SitemapURL
+	// header holds the headers of a conditional request
+	header http.Header
+	// attempt is the number of failed attempts before this one
+	attempt int
+	// reauth is set if the link is fetched again after logging in
+	reauth bool
+	// probed is set on the GET request of a link whose HEAD request was already counted as fetched
+	probed bool
+	// dropped is set if the command was fetched but not processed, it stays in the frontier
+	dropped bool
+}
+
+// newLinkCmd returns a linkCmd for the method and URL.
+func newLinkCmd(method string, u *url.URL, depth, hops int) *linkCmd {
+	return &linkCmd{Cmd: &fetchbot.Cmd{U: u, M: method}, depth: depth, hops: hops}
+}
+
+// again returns a new command to fetch the link of the command once more.
+func (c *linkCmd) again() *linkCmd {
+	next := newLinkCmd(c.Method(), c.URL(), c.depth, c.hops)
+	next.sitemap = c.sitemap
+	next.probed = c.probed
+	return next
+}
+
+// Header returns the headers of the request, it implements fetchbot's HeaderProvider.
+func (c *linkCmd) Header() http.Header {
+	return c.header
+}
+
+// linkInfo returns the depth and external hops of a Command, which are 0 for the seed or for
+// Commands that were not enqueued by the Runner.
+func linkInfo(cmd fetchbot.Command) (depth, hops int) {
+	if c, ok := cmd.(*linkCmd); ok {
+		return c.depth, c.hops
+	}
+	return 0, 0
+}
+
+// sitemapHint returns the sitemap hints of the link of a Command, nil if it was not found in a
+// sitemap.
+func sitemapHint(cmd fetchbot.Command) *SitemapURL {
+	if c, ok := cmd.(*linkCmd); ok {
+		return c.sitemap
+	}
+	return nil
+}
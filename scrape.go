@@ -2,46 +2,28 @@ package hermes
 
 import (
 	"net/http"
-	"net/url"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/fetchbot"
 	"github.com/PuerkitoBio/goquery"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// Scrape function will take a fetchbot.Context struct and a slice of tags to
-// try and scrape from the document.
-func scrape(ctx *fetchbot.Context, tags []string) (Document, error) {
-	document, err := documentResponse(ctx.Cmd.URL())
-	if err != nil {
-		return Document{}, err
-	}
-
-	scrapedDocument := scrapeDocument(ctx, document, tags)
-	return scrapedDocument, nil
+// parseResponse will parse the html body of a response fetchbot already downloaded into a
+// goquery Document. The body is read a single time so the same Document is shared by the
+// scraper and the link extraction.
+func parseResponse(res *http.Response) (*goquery.Document, error) {
+	return goquery.NewDocumentFromResponse(res)
 }
 
-func documentResponse(url *url.URL) (*goquery.Document, error) {
-	// http GET request to url's address
-	req, err := http.NewRequest("GET", url.String(), nil)
-	if err != nil {
-		return &goquery.Document{}, err
-	}
-
-	// do http GET request to url
-	resp, rerr := http.DefaultClient.Do(req)
-	if rerr != nil {
-		return &goquery.Document{}, rerr
-	}
-
-	// generate the goquery Document from io.Reader type
-	doc, rrerr := goquery.NewDocumentFromReader(resp.Body)
-	if rrerr != nil {
-		return &goquery.Document{}, rrerr
-	}
-
-	return doc, nil
+// scrape scrapes the Document of the page of a response, in a scrape span.
+func (r *Runner) scrape(ctx *fetchbot.Context, res *http.Response, doc *goquery.Document) Document {
+	span := r.startSpan(res, spanScrape)
+	defer span.End()
+	d := scrapeDocument(ctx, doc, r.Tags)
+	span.SetAttributes(attribute.Int("hermes.content.size", len(d.Content)))
+	return d
 }
 
 // function to scrape a goquery document and return a structured Document back
@@ -90,6 +72,11 @@ func scrapeDocument(ctx *fetchbot.Context, doc *goquery.Document, tags []string)
 	return d
 }
 
+// isEmpty returns true if nothing was scraped from the page of the Document.
+func isEmpty(d Document) bool {
+	return d.Title == "" && d.Description == "" && strings.TrimSpace(d.Content) == ""
+}
+
 // function to take a custom tag or "default" and return text from that in the goquery document
 func returnText(doc *goquery.Document, tag string) string {
 	var text string
@@ -0,0 +1,410 @@
+package hermes
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrInvalidFalsePositiveRate defines a bloom filter false positive rate outside of (0, 1)
+	ErrInvalidFalsePositiveRate = errors.New("false positive rate must be between 0 and 1")
+	// ErrInvalidSeenFile defines a file that is not a DiskSeenStore file
+	ErrInvalidSeenFile = errors.New("invalid seen store file")
+	// ErrInvalidBloomData defines data that is not a marshaled BloomSeenStore
+	ErrInvalidBloomData = errors.New("invalid bloom filter data")
+)
+
+// A SeenStore keeps track of the links a Runner has already enqueued to detect duplicates.
+// The keys are the canonical keys of the links (see Canonicalizer.Key). Implementations must be
+// safe for concurrent use.
+type SeenStore interface {
+	// Seen returns true if the key was already added to the store.
+	Seen(key string) (bool, error)
+	// Add adds the key to the store.
+	Add(key string) error
+}
+
+// MemorySeenStore is an exact in-memory SeenStore. It is the default store of a Runner and
+// grows with the number of links crawled.
+type MemorySeenStore struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// NewMemorySeenStore returns an empty MemorySeenStore.
+func NewMemorySeenStore() *MemorySeenStore {
+	return &MemorySeenStore{keys: make(map[string]struct{})}
+}
+
+// Seen returns true if the key was already added to the store.
+func (s *MemorySeenStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.keys[key]
+	return ok, nil
+}
+
+// Add adds the key to the store.
+func (s *MemorySeenStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = struct{}{}
+	return nil
+}
+
+// MarshalBinary encodes the keys of the store, to be saved in a Checkpoint.
+func (s *MemorySeenStore) MarshalBinary() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	return json.Marshal(keys)
+}
+
+// UnmarshalBinary adds the keys encoded by MarshalBinary to the store.
+func (s *MemorySeenStore) UnmarshalBinary(data []byte) error {
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		s.keys[k] = struct{}{}
+	}
+	return nil
+}
+
+// BloomSeenStore is a SeenStore backed by a bloom filter. It uses a fixed amount of memory for
+// multi-million link crawls at the price of false positives: a link may be reported as seen
+// (and skipped) while it was not. It never reports a seen link as new.
+type BloomSeenStore struct {
+	mu   sync.Mutex
+	bits []uint64
+	m, k uint64
+}
+
+// NewBloomSeenStore returns a BloomSeenStore sized for n links with the given false positive
+// rate (i.e. 0.001 for 0.1%).
+func NewBloomSeenStore(n int, falsePositiveRate float64) (*BloomSeenStore, error) {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, ErrInvalidFalsePositiveRate
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	// optimal number of bits and hash functions
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+
+	return &BloomSeenStore{
+		bits: make([]uint64, (uint64(m)+63)/64),
+		m:    uint64(m),
+		k:    uint64(k),
+	}, nil
+}
+
+// Seen returns true if the key was probably added to the store.
+func (s *BloomSeenStore) Seen(key string) (bool, error) {
+	h1, h2 := bloomHashes(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := uint64(0); i < s.k; i++ {
+		bit := (h1 + i*h2) % s.m
+		if s.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Add adds the key to the store.
+func (s *BloomSeenStore) Add(key string) error {
+	h1, h2 := bloomHashes(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := uint64(0); i < s.k; i++ {
+		bit := (h1 + i*h2) % s.m
+		s.bits[bit/64] |= 1 << (bit % 64)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the bloom filter, to be saved in a Checkpoint.
+func (s *BloomSeenStore) MarshalBinary() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := make([]byte, 16+len(s.bits)*8)
+	binary.LittleEndian.PutUint64(data[0:8], s.m)
+	binary.LittleEndian.PutUint64(data[8:16], s.k)
+	for i, w := range s.bits {
+		binary.LittleEndian.PutUint64(data[16+i*8:], w)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary replaces the bloom filter with the one encoded by MarshalBinary.
+func (s *BloomSeenStore) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 || (len(data)-16)%8 != 0 {
+		return ErrInvalidBloomData
+	}
+	m := binary.LittleEndian.Uint64(data[0:8])
+	k := binary.LittleEndian.Uint64(data[8:16])
+	bits := make([]uint64, (len(data)-16)/8)
+	if m == 0 || k == 0 || uint64(len(bits)) != (m+63)/64 {
+		return ErrInvalidBloomData
+	}
+	for i := range bits {
+		bits[i] = binary.LittleEndian.Uint64(data[16+i*8:])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bits, s.m, s.k = bits, m, k
+	return nil
+}
+
+// bloomHashes returns the two hashes of key used for the double hashing of the bloom filter.
+func bloomHashes(key string) (uint64, uint64) {
+	a := fnv.New64a()
+	io.WriteString(a, key)
+	b := fnv.New64()
+	io.WriteString(b, key)
+	// h2 must be odd so that the k probes don't cycle early
+	return a.Sum64(), b.Sum64() | 1
+}
+
+const (
+	// seenFileMagic identifies a DiskSeenStore file
+	seenFileMagic = "HERMSEEN"
+	// seenHeaderSize is the size of the magic, the capacity and the count
+	seenHeaderSize = 24
+	// seenInitialCapacity is the number of slots of a new DiskSeenStore file
+	seenInitialCapacity = 1 << 16
+)
+
+// DiskSeenStore is a SeenStore backed by a file, so that it can be shared across runs and keeps
+// a constant memory footprint. The file is an open addressing hash table of 64-bit fingerprints
+// of the keys that doubles in size when it is half full. Two different keys sharing the same
+// fingerprint is very unlikely but possible, in which case the second key is reported as seen.
+type DiskSeenStore struct {
+	mu       sync.Mutex
+	path     string
+	f        *os.File
+	capacity uint64
+	count    uint64
+}
+
+// OpenDiskSeenStore opens the DiskSeenStore file at path, creating it if it does not exist.
+func OpenDiskSeenStore(path string) (*DiskSeenStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &DiskSeenStore{path: path, f: f}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		err = s.init(seenInitialCapacity)
+	} else {
+		err = s.readHeader()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Seen returns true if the key was already added to the store.
+func (s *DiskSeenStore) Seen(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	found, _, err := probe(s.f, s.capacity, fingerprint(key))
+	return found, err
+}
+
+// Add adds the key to the store.
+func (s *DiskSeenStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fp := fingerprint(key)
+	found, slot, err := probe(s.f, s.capacity, fp)
+	if err != nil || found {
+		return err
+	}
+	if err := writeSeenSlot(s.f, slot, fp); err != nil {
+		return err
+	}
+	s.count++
+	if err := writeSeenHeader(s.f, s.capacity, s.count); err != nil {
+		return err
+	}
+
+	if s.count*2 >= s.capacity {
+		return s.grow()
+	}
+	return nil
+}
+
+// Len returns the number of keys in the store.
+func (s *DiskSeenStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.count)
+}
+
+// Close syncs and closes the store's file.
+func (s *DiskSeenStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+// init writes an empty table with the given capacity.
+func (s *DiskSeenStore) init(capacity uint64) error {
+	if err := s.f.Truncate(int64(seenHeaderSize + capacity*8)); err != nil {
+		return err
+	}
+	s.capacity, s.count = capacity, 0
+	return writeSeenHeader(s.f, capacity, 0)
+}
+
+func (s *DiskSeenStore) readHeader() error {
+	buf := make([]byte, seenHeaderSize)
+	if _, err := s.f.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	if string(buf[:8]) != seenFileMagic {
+		return ErrInvalidSeenFile
+	}
+	s.capacity = binary.LittleEndian.Uint64(buf[8:16])
+	s.count = binary.LittleEndian.Uint64(buf[16:24])
+	if s.capacity == 0 {
+		return ErrInvalidSeenFile
+	}
+	return nil
+}
+
+func writeSeenHeader(f *os.File, capacity, count uint64) error {
+	buf := make([]byte, seenHeaderSize)
+	copy(buf, seenFileMagic)
+	binary.LittleEndian.PutUint64(buf[8:16], capacity)
+	binary.LittleEndian.PutUint64(buf[16:24], count)
+	_, err := f.WriteAt(buf, 0)
+	return err
+}
+
+func writeSeenSlot(f *os.File, slot, fp uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, fp)
+	_, err := f.WriteAt(buf, int64(seenHeaderSize+slot*8))
+	return err
+}
+
+// grow doubles the capacity of the table by rehashing it into a new file that replaces the
+// current one.
+func (s *DiskSeenStore) grow() error {
+	tmp := s.path + ".tmp"
+	nf, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	capacity := s.capacity * 2
+	if err := nf.Truncate(int64(seenHeaderSize + capacity*8)); err != nil {
+		nf.Close()
+		return err
+	}
+
+	// rehash every fingerprint of the current table in chunks
+	buf := make([]byte, 8*4096)
+	for off := uint64(0); off < s.capacity; off += 4096 {
+		n := s.capacity - off
+		if n > 4096 {
+			n = 4096
+		}
+		if _, err := s.f.ReadAt(buf[:n*8], int64(seenHeaderSize+off*8)); err != nil {
+			nf.Close()
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			fp := binary.LittleEndian.Uint64(buf[i*8:])
+			if fp == 0 {
+				continue
+			}
+			_, slot, err := probe(nf, capacity, fp)
+			if err == nil {
+				err = writeSeenSlot(nf, slot, fp)
+			}
+			if err != nil {
+				nf.Close()
+				return err
+			}
+		}
+	}
+	if err := writeSeenHeader(nf, capacity, s.count); err != nil {
+		nf.Close()
+		return err
+	}
+	if err := nf.Sync(); err != nil {
+		nf.Close()
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		nf.Close()
+		return err
+	}
+	s.f.Close()
+	s.f, s.capacity = nf, capacity
+	return nil
+}
+
+// probe looks for the fingerprint in the table of the file with linear probing.
+func probe(f *os.File, capacity, fp uint64) (bool, uint64, error) {
+	buf := make([]byte, 8)
+	slot := fp % capacity
+	for i := uint64(0); i < capacity; i++ {
+		if _, err := f.ReadAt(buf, int64(seenHeaderSize+slot*8)); err != nil {
+			return false, 0, err
+		}
+		switch binary.LittleEndian.Uint64(buf) {
+		case 0:
+			return false, slot, nil
+		case fp:
+			return true, slot, nil
+		}
+		slot = (slot + 1) % capacity
+	}
+	return false, 0, ErrInvalidSeenFile
+}
+
+// fingerprint returns the 64-bit fingerprint of key, 0 is reserved for the empty slots.
+func fingerprint(key string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, key)
+	if fp := h.Sum64(); fp != 0 {
+		return fp
+	}
+	return 1
+}
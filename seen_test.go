@@ -0,0 +1,215 @@
+package hermes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemorySeenStore(t *testing.T) {
+	s := NewMemorySeenStore()
+	testSeenStore(t, s)
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMemorySeenStore()
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	assertSeen(t, loaded, 0, 100)
+}
+
+func TestBloomSeenStore(t *testing.T) {
+	s, err := NewBloomSeenStore(1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSeenStore(t, s)
+
+	// the false positive rate stays close to the one it was sized for
+	for i := 0; i < 1000; i++ {
+		s.Add(fmt.Sprintf("//example.com/page/%d", i))
+	}
+	positives := 0
+	for i := 0; i < 10000; i++ {
+		if seen, _ := s.Seen(fmt.Sprintf("//example.org/other/%d", i)); seen {
+			positives++
+		}
+	}
+	if rate := float64(positives) / 10000; rate > 0.03 {
+		t.Errorf("false positive rate = %v, want about 0.01", rate)
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &BloomSeenStore{}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	assertSeen(t, loaded, 0, 100)
+	if loaded.m != s.m || loaded.k != s.k {
+		t.Errorf("m, k = %d, %d, want %d, %d", loaded.m, loaded.k, s.m, s.k)
+	}
+}
+
+func TestBloomSeenStoreInvalid(t *testing.T) {
+	for _, rate := range []float64{0, 1, -0.1, 2} {
+		if _, err := NewBloomSeenStore(100, rate); err != ErrInvalidFalsePositiveRate {
+			t.Errorf("NewBloomSeenStore(100, %v): err = %v, want ErrInvalidFalsePositiveRate", rate, err)
+		}
+	}
+
+	s, err := NewBloomSeenStore(100, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := s.MarshalBinary()
+	for _, d := range [][]byte{nil, data[:8], data[:len(data)-8], data[:len(data)-1], make([]byte, 24)} {
+		if err := (&BloomSeenStore{}).UnmarshalBinary(d); err != ErrInvalidBloomData {
+			t.Errorf("UnmarshalBinary(%d bytes): err = %v, want ErrInvalidBloomData", len(d), err)
+		}
+	}
+}
+
+func TestDiskSeenStore(t *testing.T) {
+	path := filepath.Join(tempDir(t), "seen")
+	s, err := OpenDiskSeenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSeenStore(t, s)
+	if s.Len() != 100 {
+		t.Errorf("Len = %d, want 100", s.Len())
+	}
+
+	// adding a key twice doesn't count it twice
+	if err := s.Add(seenKey(0)); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 100 {
+		t.Errorf("Len = %d after a duplicate, want 100", s.Len())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys are still there once reopened
+	s, err = OpenDiskSeenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != 100 {
+		t.Errorf("Len = %d after reopening, want 100", s.Len())
+	}
+	assertSeen(t, s, 0, 100)
+	assertNotSeen(t, s, 100, 200)
+}
+
+func TestDiskSeenStoreGrow(t *testing.T) {
+	path := filepath.Join(tempDir(t), "seen")
+	s, err := OpenDiskSeenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the table doubles when it is half full
+	n := seenInitialCapacity/2 + 1000
+	for i := 0; i < n; i++ {
+		if err := s.Add(seenKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.capacity != seenInitialCapacity*2 {
+		t.Errorf("capacity = %d, want %d", s.capacity, seenInitialCapacity*2)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("the temporary file of the growth was not renamed: %v", err)
+	}
+	assertSeen(t, s, 0, n)
+	assertNotSeen(t, s, n, n+1000)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenDiskSeenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.capacity != seenInitialCapacity*2 || s.Len() != n {
+		t.Errorf("capacity, Len = %d, %d after reopening, want %d, %d", s.capacity, s.Len(), seenInitialCapacity*2, n)
+	}
+	assertSeen(t, s, 0, n)
+}
+
+func TestDiskSeenStoreInvalidFile(t *testing.T) {
+	path := filepath.Join(tempDir(t), "seen")
+	if err := ioutil.WriteFile(path, []byte("not a seen store file at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDiskSeenStore(path); err != ErrInvalidSeenFile {
+		t.Errorf("err = %v, want ErrInvalidSeenFile", err)
+	}
+}
+
+// testSeenStore adds the keys 0 to 99 to the store and checks that only they are seen.
+func testSeenStore(t *testing.T, s SeenStore) {
+	t.Helper()
+	assertNotSeen(t, s, 0, 100)
+	for i := 0; i < 100; i++ {
+		if err := s.Add(seenKey(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertSeen(t, s, 0, 100)
+	assertNotSeen(t, s, 100, 200)
+}
+
+func assertSeen(t *testing.T, s SeenStore, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if seen, err := s.Seen(seenKey(i)); err != nil || !seen {
+			t.Fatalf("Seen(%q) = %v, %v, want true", seenKey(i), seen, err)
+		}
+	}
+}
+
+// assertNotSeen checks that the keys were not seen. A bloom filter may have a few false
+// positives, so a tenth of the keys are allowed to be seen.
+func assertNotSeen(t *testing.T, s SeenStore, from, to int) {
+	t.Helper()
+	positives := 0
+	for i := from; i < to; i++ {
+		seen, err := s.Seen(seenKey(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen {
+			positives++
+		}
+	}
+	if _, bloom := s.(*BloomSeenStore); !bloom && positives > 0 || positives > (to-from)/10 {
+		t.Fatalf("%d keys between %d and %d seen, want none", positives, from, to)
+	}
+}
+
+func seenKey(i int) string {
+	return fmt.Sprintf("//example.com/page/%d", i)
+}
+
+// tempDir returns a directory removed at the end of the test.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "hermes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
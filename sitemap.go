@@ -0,0 +1,270 @@
+package hermes
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+// maxSitemapSize is the maximum size of an uncompressed sitemap (50MB per the sitemaps protocol)
+const maxSitemapSize = 50 << 20
+
+// ErrSitemapTooLarge defines a sitemap larger than the 50MB allowed by the sitemaps protocol
+var ErrSitemapTooLarge = errors.New("sitemap too large")
+
+type (
+	// A SitemapURL is a URL found in a sitemap with its hints. The Priority defaults to 0.5 and the
+	// LastMod is zero if the sitemap doesn't set it. News and Images hold the news and image
+	// sitemap extensions.
+	SitemapURL struct {
+		Loc        string       `json:"loc"`
+		LastMod    time.Time    `json:"lastmod,omitempty"`
+		ChangeFreq string       `json:"changefreq,omitempty"`
+		Priority   float64      `json:"priority"`
+		News       *SitemapNews `json:"news,omitempty"`
+		Images     []string     `json:"images,omitempty"`
+	}
+
+	// SitemapNews holds the news sitemap extension of a SitemapURL.
+	SitemapNews struct {
+		Name            string    `json:"name"`
+		Language        string    `json:"language"`
+		Title           string    `json:"title"`
+		PublicationDate time.Time `json:"publication_date"`
+	}
+
+	// Sitemap is a parsed sitemap, which is either a list of URLs (<urlset>) or a list of other
+	// sitemaps (<sitemapindex>).
+	Sitemap struct {
+		URLs     []SitemapURL
+		Sitemaps []string
+	}
+)
+
+// xml models of the sitemaps protocol and of its news and image extensions
+type (
+	xmlSitemap struct {
+		URLs     []xmlSitemapURL `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+
+	xmlSitemapURL struct {
+		Loc        string `xml:"loc"`
+		LastMod    string `xml:"lastmod"`
+		ChangeFreq string `xml:"changefreq"`
+		Priority   string `xml:"priority"`
+		News       *struct {
+			Publication struct {
+				Name     string `xml:"name"`
+				Language string `xml:"language"`
+			} `xml:"publication"`
+			PublicationDate string `xml:"publication_date"`
+			Title           string `xml:"title"`
+		} `xml:"news"`
+		Images []struct {
+			Loc string `xml:"loc"`
+		} `xml:"image"`
+	}
+)
+
+// ParseSitemap parses a sitemap or a sitemap index, gzipped or not.
+func ParseSitemap(rd io.Reader) (*Sitemap, error) {
+	br := bufio.NewReader(rd)
+
+	// gzipped sitemaps start with the gzip magic number
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		rd = gz
+	} else {
+		rd = br
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(rd, maxSitemapSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSitemapSize {
+		return nil, ErrSitemapTooLarge
+	}
+
+	var x xmlSitemap
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&x); err != nil {
+		return nil, err
+	}
+
+	sm := &Sitemap{}
+	for _, s := range x.Sitemaps {
+		if loc := strings.TrimSpace(s.Loc); loc != "" {
+			sm.Sitemaps = append(sm.Sitemaps, loc)
+		}
+	}
+	for _, xu := range x.URLs {
+		u := SitemapURL{
+			Loc:        strings.TrimSpace(xu.Loc),
+			LastMod:    parseW3CTime(xu.LastMod),
+			ChangeFreq: strings.TrimSpace(xu.ChangeFreq),
+			Priority:   0.5,
+		}
+		if u.Loc == "" {
+			continue
+		}
+		if p, err := strconv.ParseFloat(strings.TrimSpace(xu.Priority), 64); err == nil {
+			u.Priority = p
+		}
+		if xu.News != nil {
+			u.News = &SitemapNews{
+				Name:            strings.TrimSpace(xu.News.Publication.Name),
+				Language:        strings.TrimSpace(xu.News.Publication.Language),
+				Title:           strings.TrimSpace(xu.News.Title),
+				PublicationDate: parseW3CTime(xu.News.PublicationDate),
+			}
+		}
+		for _, img := range xu.Images {
+			if loc := strings.TrimSpace(img.Loc); loc != "" {
+				u.Images = append(u.Images, loc)
+			}
+		}
+		sm.URLs = append(sm.URLs, u)
+	}
+	return sm, nil
+}
+
+// parseW3CTime parses the W3C datetime formats allowed in sitemaps. It returns the zero time if
+// the value can't be parsed.
+func parseW3CTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", "2006-01-02T15:04:05", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// discoverSitemaps enqueues the robots.txt of the seed's host, to find its "Sitemap:" lines, and
+// the /sitemap.xml of the host. Both go through the fetchbot Queue so the crawl delay, user agent
+// and robots.txt policies still apply. It returns the number of requests enqueued.
+func (r *Runner) discoverSitemaps(q *fetchbot.Queue) int {
+	n := 0
+	robots := r.URL.ResolveReference(&url.URL{Path: "/robots.txt"})
+	cmd, err := fetchbot.NewHandlerCmd("GET", robots.String(), r.robotsSitemapHandler)
+	if err == nil {
+		err = q.Send(cmd)
+	}
+	if err != nil {
+		r.log().Error("enqueue failed", requestFields("GET", robots, 0, err))
+	} else {
+		n++
+	}
+
+	if r.enqueueSitemap(q, r.URL.ResolveReference(&url.URL{Path: "/sitemap.xml"})) {
+		n++
+	}
+	return n
+}
+
+// enqueueSitemap enqueues a sitemap if it was not enqueued already. It returns true if the
+// sitemap was enqueued.
+func (r *Runner) enqueueSitemap(q *fetchbot.Queue, u *url.URL) bool {
+	r.mu.Lock()
+	if r.sitemaps[u.String()] {
+		r.mu.Unlock()
+		return false
+	}
+	r.sitemaps[u.String()] = true
+	r.mu.Unlock()
+
+	cmd, err := fetchbot.NewHandlerCmd("GET", u.String(), r.sitemapHandler)
+	if err == nil {
+		err = q.Send(cmd)
+	}
+	if err != nil {
+		r.log().Error("enqueue failed", requestFields("GET", u, 0, err))
+		return false
+	}
+	return true
+}
+
+// robotsSitemapHandler enqueues the sitemaps listed in a robots.txt response.
+func (r *Runner) robotsSitemapHandler(ctx *fetchbot.Context, res *http.Response, err error) {
+	if err != nil {
+		r.log().Error("request failed", cmdFields(ctx.Cmd, 0, err))
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		return
+	}
+
+	sc := bufio.NewScanner(io.LimitReader(res.Body, maxSitemapSize))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) < 8 || !strings.EqualFold(line[:8], "sitemap:") {
+			continue
+		}
+		u, err := resolveLink(ctx.Cmd.URL(), line[8:])
+		if err != nil {
+			r.log().Warn("invalid sitemap link", Fields{"url": ctx.Cmd.URL().String(), "host": ctx.Cmd.URL().Host, "sitemap": line[8:], "error": err})
+			continue
+		}
+		r.enqueueSitemap(ctx.Q, u)
+	}
+}
+
+// sitemapHandler parses a sitemap response. The sitemaps of a sitemap index are enqueued and the
+// URLs of a sitemap are added to the frontier, by decreasing priority.
+func (r *Runner) sitemapHandler(ctx *fetchbot.Context, res *http.Response, err error) {
+	if err != nil {
+		r.log().Error("request failed", cmdFields(ctx.Cmd, 0, err))
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		r.log().Info("no sitemap", cmdFields(ctx.Cmd, res.StatusCode, nil))
+		return
+	}
+
+	sm, err := ParseSitemap(res.Body)
+	if err != nil {
+		r.log().Error("parsing sitemap failed", cmdFields(ctx.Cmd, res.StatusCode, err))
+		return
+	}
+
+	for _, loc := range sm.Sitemaps {
+		u, err := resolveLink(ctx.Cmd.URL(), loc)
+		if err != nil {
+			r.log().Warn("invalid sitemap link", Fields{"url": ctx.Cmd.URL().String(), "host": ctx.Cmd.URL().Host, "sitemap": loc, "error": err})
+			continue
+		}
+		r.enqueueSitemap(ctx.Q, u)
+	}
+
+	sort.SliceStable(sm.URLs, func(i, j int) bool {
+		return sm.URLs[i].Priority > sm.URLs[j].Priority
+	})
+	for i := range sm.URLs {
+		u, err := resolveLink(ctx.Cmd.URL(), sm.URLs[i].Loc)
+		if err != nil {
+			r.log().Warn("invalid sitemap link", Fields{"url": ctx.Cmd.URL().String(), "host": ctx.Cmd.URL().Host, "link": sm.URLs[i].Loc, "error": err})
+			continue
+		}
+		// sitemap URLs are seeds of the crawl
+		r.enqueueLink(ctx.Q, ctx.Cmd.URL(), u, 0, 0, &sm.URLs[i])
+	}
+}
@@ -0,0 +1,155 @@
+package hermes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testURLSet = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+	xmlns:news="http://www.google.com/schemas/sitemap-news/0.9"
+	xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+	<url>
+		<loc> https://example.com/ </loc>
+		<lastmod>2024-01-02</lastmod>
+		<changefreq>daily</changefreq>
+		<priority>1.0</priority>
+	</url>
+	<url>
+		<loc>https://example.com/news/1</loc>
+		<lastmod>2024-01-02T10:30:00+01:00</lastmod>
+		<news:news>
+			<news:publication>
+				<news:name>Example News</news:name>
+				<news:language>en</news:language>
+			</news:publication>
+			<news:publication_date>2024-01-02T10:00:00Z</news:publication_date>
+			<news:title>A title</news:title>
+		</news:news>
+		<image:image><image:loc>https://example.com/1.jpg</image:loc></image:image>
+		<image:image><image:loc>https://example.com/2.jpg</image:loc></image:image>
+	</url>
+	<url>
+		<loc>https://example.com/no-hints</loc>
+		<lastmod>yesterday</lastmod>
+		<priority>high</priority>
+	</url>
+	<url>
+		<loc></loc>
+	</url>
+</urlset>`
+
+const testSitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.com/sitemap-1.xml</loc><lastmod>2024-01-02</lastmod></sitemap>
+	<sitemap><loc> https://example.com/sitemap-2.xml.gz </loc></sitemap>
+	<sitemap><loc></loc></sitemap>
+</sitemapindex>`
+
+func TestParseSitemapURLSet(t *testing.T) {
+	sm, err := ParseSitemap(strings.NewReader(testURLSet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.Sitemaps) != 0 || len(sm.URLs) != 3 {
+		t.Fatalf("%d URLs and %d sitemaps, want 3 URLs", len(sm.URLs), len(sm.Sitemaps))
+	}
+
+	u := sm.URLs[0]
+	if u.Loc != "https://example.com/" || u.ChangeFreq != "daily" || u.Priority != 1 ||
+		!u.LastMod.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) || u.News != nil || u.Images != nil {
+		t.Errorf("URLs[0] = %+v", u)
+	}
+
+	u = sm.URLs[1]
+	if !u.LastMod.Equal(time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)) || u.Priority != 0.5 {
+		t.Errorf("URLs[1] = %+v", u)
+	}
+	want := SitemapNews{Name: "Example News", Language: "en", Title: "A title", PublicationDate: time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)}
+	if u.News == nil || u.News.Name != want.Name || u.News.Language != want.Language || u.News.Title != want.Title ||
+		!u.News.PublicationDate.Equal(want.PublicationDate) {
+		t.Errorf("URLs[1].News = %+v, want %+v", u.News, want)
+	}
+	if len(u.Images) != 2 || u.Images[0] != "https://example.com/1.jpg" || u.Images[1] != "https://example.com/2.jpg" {
+		t.Errorf("URLs[1].Images = %v", u.Images)
+	}
+
+	// invalid hints fall back to their defaults
+	u = sm.URLs[2]
+	if u.Loc != "https://example.com/no-hints" || !u.LastMod.IsZero() || u.Priority != 0.5 {
+		t.Errorf("URLs[2] = %+v", u)
+	}
+}
+
+func TestParseSitemapIndex(t *testing.T) {
+	sm, err := ParseSitemap(strings.NewReader(testSitemapIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.URLs) != 0 || len(sm.Sitemaps) != 2 ||
+		sm.Sitemaps[0] != "https://example.com/sitemap-1.xml" || sm.Sitemaps[1] != "https://example.com/sitemap-2.xml.gz" {
+		t.Errorf("URLs = %v, Sitemaps = %q, want the 2 sitemaps", sm.URLs, sm.Sitemaps)
+	}
+}
+
+func TestParseSitemapGzip(t *testing.T) {
+	sm, err := ParseSitemap(gzipped(t, strings.NewReader(testSitemapIndex)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.Sitemaps) != 2 {
+		t.Errorf("Sitemaps = %q, want 2", sm.Sitemaps)
+	}
+
+	if _, err := ParseSitemap(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0})); err == nil {
+		t.Error("truncated gzip: expected an error")
+	}
+}
+
+func TestParseSitemapTooLarge(t *testing.T) {
+	large := func() io.Reader {
+		return io.MultiReader(strings.NewReader("<urlset>"), io.LimitReader(spaces{}, maxSitemapSize), strings.NewReader("</urlset>"))
+	}
+	if _, err := ParseSitemap(large()); err != ErrSitemapTooLarge {
+		t.Errorf("err = %v, want ErrSitemapTooLarge", err)
+	}
+	// the limit applies to the uncompressed size
+	if _, err := ParseSitemap(gzipped(t, large())); err != ErrSitemapTooLarge {
+		t.Errorf("gzipped: err = %v, want ErrSitemapTooLarge", err)
+	}
+}
+
+func TestParseSitemapInvalid(t *testing.T) {
+	for _, s := range []string{"", "not xml", "<urlset><url><loc>x</url>"} {
+		if _, err := ParseSitemap(strings.NewReader(s)); err == nil {
+			t.Errorf("ParseSitemap(%q): expected an error", s)
+		}
+	}
+}
+
+// gzipped returns the gzip compression of the reader's content.
+func gzipped(t *testing.T, rd io.Reader) io.Reader {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := io.Copy(gz, rd); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &b
+}
+
+// spaces is an endless reader of spaces.
+type spaces struct{}
+
+func (spaces) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
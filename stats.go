@@ -1,57 +1,39 @@
 package hermes
 
 import (
-	"bytes"
-	"fmt"
 	"runtime"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/fetchbot"
 )
 
-// runMemStats controls the debugging and memory allocation statistics
-func runMemStats(f *fetchbot.Fetcher, tick time.Duration) {
-	var mu sync.Mutex
-	var di *fetchbot.DebugInfo
-
+// runMemStats controls the debugging and memory allocation statistics, with the debug info of
+// the fetcher returned by di
+func runMemStats(di func() *fetchbot.DebugInfo, tick time.Duration, l Logger) {
 	// convert time.Duration to seconds intervals
 	tick = tick * time.Second
 
-	// Start goroutine to collect fetchbot debug info
-	go func() {
-		for v := range f.Debug() {
-			mu.Lock()
-			di = v
-			mu.Unlock()
-		}
-	}()
 	// Start ticker goroutine to print mem stats at regular intervals
 	go func() {
 		c := time.Tick(tick)
 		for _ = range c {
-			mu.Lock()
-			printMemStats(di)
-			mu.Unlock()
+			printMemStats(di(), l)
 		}
 	}()
 }
 
-// printMemStats prints out the memory profile of the application at a given time's state
-func printMemStats(di *fetchbot.DebugInfo) {
+// printMemStats logs the memory profile of the application at a given time's state
+func printMemStats(di *fetchbot.DebugInfo, l Logger) {
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
-	buf := bytes.NewBuffer(nil)
-	buf.WriteString(strings.Repeat("=", 72) + "\n")
-	buf.WriteString("Memory Profile:\n")
-	buf.WriteString(fmt.Sprintf("\tAlloc: %d Kb\n", mem.Alloc/1024))
-	buf.WriteString(fmt.Sprintf("\tTotalAlloc: %d Kb\n", mem.TotalAlloc/1024))
-	buf.WriteString(fmt.Sprintf("\tNumGC: %d\n", mem.NumGC))
-	buf.WriteString(fmt.Sprintf("\tGoroutines: %d\n", runtime.NumGoroutine()))
+	f := Fields{
+		"alloc_kb":       mem.Alloc / 1024,
+		"total_alloc_kb": mem.TotalAlloc / 1024,
+		"num_gc":         mem.NumGC,
+		"goroutines":     runtime.NumGoroutine(),
+	}
 	if di != nil {
-		buf.WriteString(fmt.Sprintf("\tNumHosts: %d\n", di.NumHosts))
+		f["num_hosts"] = di.NumHosts
 	}
-	buf.WriteString(strings.Repeat("=", 72))
-	fmt.Println(buf.String())
+	l.Info("memory profile", f)
 }
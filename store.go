@@ -3,11 +3,12 @@ package hermes
 import (
 	"encoding/base64"
 	"errors"
-	"fmt"
 	"math/rand"
 	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/olivere/elastic.v5"
@@ -35,6 +36,10 @@ type (
 		Link        string    `json:"link"`
 		Tag         string    `json:"tag"`
 		Time        time.Time `json:"time"`
+		Unchanged   bool      `json:"unchanged,omitempty"`
+
+		// The Sitemap holds the hints (lastmod, priority, etc.) of the sitemap the page was found in, if any.
+		Sitemap *SitemapURL `json:"sitemap,omitempty"`
 	}
 
 	// IngestionDocument struct to model our ingestion set for multiple types and Documents
@@ -54,12 +59,25 @@ type (
 	// It must have a host, index and type to ingest data to.
 	Elasticsearch struct {
 		Host, Index, Type string
+
+		// The Logger receives the progress and the stats of the bulk inserts. If it is nil nothing is logged.
+		Logger Logger
+
+		// The Metrics collects the latency and failures of the bulk inserts and the number of Documents stored.
+		Metrics *Metrics
+
+		// The TracerProvider traces the Store calls and their bulk inserts. If it is nil nothing is traced.
+		TracerProvider trace.TracerProvider
 	}
 )
 
 // Store function will take total documents, es host, es index, es type and the Documents to be ingested.
 // It will return with an error if faulted or will print stats on ingestion process (Total, Requests/sec, Time to ingest)
-func (e *Elasticsearch) Store(n int, docs []Document) error {
+func (e *Elasticsearch) Store(n int, docs []Document) (err error) {
+	ctx, span := tracer(e.TracerProvider).Start(context.TODO(), spanStore,
+		trace.WithAttributes(attribute.String("db.system", "elasticsearch"), attribute.String("db.namespace", e.Index), attribute.Int("hermes.documents", len(docs))))
+	defer func() { endSpan(span, err) }()
+
 	rand.Seed(time.Now().UnixNano())
 
 	if e.Host == "" {
@@ -82,7 +100,7 @@ func (e *Elasticsearch) Store(n int, docs []Document) error {
 	}
 
 	// Setup a group of goroutines from the errgroup package
-	g, ctx := errgroup.WithContext(context.TODO())
+	g, ctx := errgroup.WithContext(ctx)
 
 	// The first goroutine will emit documents and send it to the second goroutine
 	// via the docsc channel.
@@ -97,6 +115,10 @@ func (e *Elasticsearch) Store(n int, docs []Document) error {
 
 		buf := make([]byte, 32)
 		for _, v := range docs {
+			// unchanged documents are already in the index
+			if v.Unchanged {
+				continue
+			}
 
 			_, err := rand.Read(buf)
 			if err != nil {
@@ -104,7 +126,7 @@ func (e *Elasticsearch) Store(n int, docs []Document) error {
 			}
 			v.ID = base64.URLEncoding.EncodeToString(buf)
 
-			fmt.Printf("new ID: %s\n", v.ID)
+			logger(e.Logger).Debug("new ID", Fields{"id": v.ID, "url": v.Link})
 
 			// Send over to 2nd goroutine, or cancel
 			select {
@@ -126,20 +148,15 @@ func (e *Elasticsearch) Store(n int, docs []Document) error {
 			dur := time.Since(begin).Seconds()
 			sec := int(dur)
 			pps := int64(float64(current) / dur)
-			fmt.Printf("%10d | %6d req/s | %02d:%02d\r", current, pps, sec/60, sec%60)
+			logger(e.Logger).Debug("progress", Fields{"documents": current, "rate": pps, "elapsed": time.Duration(sec) * time.Second})
 
 			// Enqueue the document
 			bulk.Add(elastic.NewBulkIndexRequest().Id(d.ID).Doc(d))
 			if bulk.NumberOfActions() >= 1000 {
 				// Commit
-				res, err := bulk.Do(ctx)
-				if err != nil {
+				if err := e.commit(ctx, bulk); err != nil {
 					return err
 				}
-				if res.Errors {
-					// Look up the failed documents with res.Failed(), and e.g. recommit
-					return errors.New("bulk commit failed")
-				}
 
 				// elasticsearch bulk insert function is enabled again after .Do ("commit")
 				// "bulk" is reset after Do, so you can reuse it
@@ -154,8 +171,7 @@ func (e *Elasticsearch) Store(n int, docs []Document) error {
 
 		// Commit the final batch before exiting
 		if bulk.NumberOfActions() > 0 {
-			_, err = bulk.Do(ctx)
-			if err != nil {
+			if err := e.commit(ctx, bulk); err != nil {
 				return err
 			}
 		}
@@ -171,7 +187,25 @@ func (e *Elasticsearch) Store(n int, docs []Document) error {
 	dur := time.Since(begin).Seconds()
 	sec := int(dur)
 	pps := int64(float64(total) / dur)
-	fmt.Printf("\n\n|- %10d -|- %6d req/s -|- %02d:%02d -|\n", total, pps, sec/60, sec%60)
+	logger(e.Logger).Info("stored", Fields{"documents": total, "rate": pps, "elapsed": time.Duration(sec) * time.Second})
 
 	return nil
 }
+
+// commit sends the bulk request and records its latency in the Metrics.
+func (e *Elasticsearch) commit(ctx context.Context, bulk *elastic.BulkService) error {
+	n := bulk.NumberOfActions()
+	ctx, span := tracer(e.TracerProvider).Start(ctx, spanBulk,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "elasticsearch"), attribute.String("db.namespace", e.Index), attribute.Int("hermes.documents", n),
+			attribute.Int64("http.request.body.size", bulk.EstimatedSizeInBytes())))
+	start := time.Now()
+	res, err := bulk.Do(ctx)
+	if err == nil && res.Errors {
+		// Look up the failed documents with res.Failed(), and e.g. recommit
+		err = errors.New("bulk commit failed")
+	}
+	e.Metrics.observeBulk(n, time.Since(start), err)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,213 @@
+package hermes
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/fetchbot"
+)
+
+// A Throttle rate limits the requests of a Runner per host. Every host starts at its base delay
+// (the Runner's CrawlDelay or its HostDelays override). The delay backs off when a host answers
+// 429 Too Many Requests or 503 Service Unavailable, or when its latency rises, honors the
+// Retry-After header and recovers gradually on successful responses. Unlike the Runner's
+// fields, the durations of a Throttle are real durations (i.e. 500 * time.Millisecond).
+type Throttle struct {
+	// The HostDelays are per-host base delays that override the Runner's CrawlDelay, keyed by host name
+	// (i.e. "docs.example.com"). Use them to crawl your own properties fast and third-party sites politely.
+	HostDelays map[string]time.Duration
+
+	// The MaxDelay caps the delay of a host when it backs off, and the wait asked for by a Retry-After header.
+	MaxDelay time.Duration
+
+	// The BackoffFactor multiplies the delay of a host on a 429/503 response or a latency spike.
+	BackoffFactor float64
+
+	// The RecoveryFactor multiplies the delay of a host on a successful response, until it is back to its base delay.
+	RecoveryFactor float64
+
+	// The LatencyFactor is how many times the host's average latency a response must take to count as a latency spike.
+	// Set it to 0 to ignore latency.
+	LatencyFactor float64
+
+	mu    sync.Mutex
+	hosts map[string]*hostThrottle
+}
+
+// hostThrottle is the rate limiting state of a single host.
+type hostThrottle struct {
+	mu      sync.Mutex
+	base    time.Duration
+	delay   time.Duration
+	next    time.Time
+	latency time.Duration
+	samples int
+}
+
+// NewThrottle returns a Throttle with default backoff settings. These values can be overwritten
+// after initializing the new Throttle reference.
+func NewThrottle() *Throttle {
+	return &Throttle{
+		HostDelays:     make(map[string]time.Duration),
+		MaxDelay:       2 * time.Minute,
+		BackoffFactor:  2,
+		RecoveryFactor: 0.75,
+		LatencyFactor:  3,
+	}
+}
+
+// Delay returns the current delay between two requests to host, 0 if the host was not
+// requested yet.
+func (t *Throttle) Delay(host string) time.Duration {
+	t.mu.Lock()
+	h, ok := t.hosts[host]
+	t.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delay
+}
+
+// host returns the state of a host, creating it with the base delay if needed.
+func (t *Throttle) host(host string, base time.Duration) *hostThrottle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hosts == nil {
+		t.hosts = make(map[string]*hostThrottle)
+	}
+	h, ok := t.hosts[host]
+	if !ok {
+		if d, ok := t.HostDelays[host]; ok {
+			base = d
+		} else if d, ok := t.HostDelays[hostname(host)]; ok {
+			base = d
+		}
+		h = &hostThrottle{base: base, delay: base}
+		t.hosts[host] = h
+	}
+	return h
+}
+
+// wait blocks until the host can be requested again and reserves the next slot. It returns false
+// if done is closed first.
+func (h *hostThrottle) wait(done <-chan struct{}) bool {
+	h.mu.Lock()
+	now := time.Now()
+	next := h.next
+	if next.Before(now) {
+		next = now
+	}
+	h.next = next.Add(h.delay)
+	h.mu.Unlock()
+
+	t := time.NewTimer(next.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// observe adapts the delay of the host to a response.
+func (t *Throttle) observe(h *hostThrottle, res *http.Response, err error, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	spike := t.LatencyFactor > 0 && h.samples >= 5 && float64(latency) > t.LatencyFactor*float64(h.latency)
+	// exponentially weighted moving average of the latency
+	if h.samples == 0 {
+		h.latency = latency
+	} else {
+		h.latency = (h.latency*4 + latency) / 5
+	}
+	h.samples++
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable:
+		h.backoff(t)
+		if after, ok := retryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			if t.MaxDelay > 0 && after > t.MaxDelay {
+				after = t.MaxDelay
+			}
+			if next := time.Now().Add(after); next.After(h.next) {
+				h.next = next
+			}
+		}
+	case spike:
+		h.backoff(t)
+	default:
+		// recover gradually
+		if h.delay > h.base {
+			h.delay = time.Duration(float64(h.delay) * t.RecoveryFactor)
+			if h.delay < h.base {
+				h.delay = h.base
+			}
+		}
+	}
+}
+
+// backoff increases the delay of the host.
+func (h *hostThrottle) backoff(t *Throttle) {
+	d := time.Duration(float64(h.delay) * t.BackoffFactor)
+	if d < time.Second {
+		d = time.Second
+	}
+	if t.MaxDelay > 0 && d > t.MaxDelay {
+		d = t.MaxDelay
+	}
+	h.delay = d
+}
+
+// retryAfter parses a Retry-After header, either a number of seconds or an HTTP date. A date in
+// the past asks for no delay.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if t.Before(now) {
+			return 0, true
+		}
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// throttledDoer is a fetchbot Doer that rate limits the requests of the wrapped Doer with a
+// Throttle. fetchbot runs a goroutine per host, so waiting here only slows that host down. The
+// wait ends early when done is closed.
+type throttledDoer struct {
+	t    *Throttle
+	base time.Duration
+	done <-chan struct{}
+	doer fetchbot.Doer
+}
+
+// Do waits for the host's turn, then does the request and adapts the host's delay. It fails with
+// ErrCancelled if the crawl is cancelled while it waits.
+func (d *throttledDoer) Do(req *http.Request) (*http.Response, error) {
+	h := d.t.host(req.URL.Host, d.base)
+	if !h.wait(d.done) {
+		return nil, ErrCancelled
+	}
+
+	start := time.Now()
+	res, err := d.doer.Do(req)
+	d.t.observe(h, res, err, time.Since(start))
+	return res, err
+}
@@ -0,0 +1,39 @@
+package hermes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(1994, 11, 6, 8, 49, 0, 0, time.UTC)
+	for _, c := range []struct {
+		v    string
+		want time.Duration
+		ok   bool
+	}{
+		// seconds
+		{"120", 2 * time.Minute, true},
+		{" 30 ", 30 * time.Second, true},
+		{"0", 0, true},
+		{"-5", 0, false},
+		{"1.5", 0, false},
+
+		// HTTP dates, in the RFC 1123, RFC 850 and ANSI C formats
+		{"Sun, 06 Nov 1994 08:49:37 GMT", 37 * time.Second, true},
+		{"Sunday, 06-Nov-94 08:49:37 GMT", 37 * time.Second, true},
+		{"Sun Nov  6 08:49:37 1994", 37 * time.Second, true},
+		{"Sun, 06 Nov 1994 09:49:00 GMT", time.Hour, true},
+		{"Sun, 06 Nov 1994 08:00:00 GMT", 0, true},
+
+		// invalid values
+		{"", 0, false},
+		{"soon", 0, false},
+		{"1994-11-06T08:49:37Z", 0, false},
+	} {
+		got, ok := retryAfter(c.v, now)
+		if got != c.want || ok != c.ok {
+			t.Errorf("retryAfter(%q) = %v, %t, want %v, %t", c.v, got, ok, c.want, c.ok)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package hermes
+
+import (
+	"net/http"
+
+	"github.com/PuerkitoBio/fetchbot"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+// tracerName is the instrumentation scope of the spans of hermes.
+const tracerName = "github.com/jtaylor32/hermes"
+
+// The spans of a crawl. A fetch (or robots) span lasts until the body of the response is
+// closed, so the parse, links and scrape spans of the page are its children.
+const (
+	spanFetch  = "hermes.fetch"
+	spanRobots = "hermes.robots"
+	spanParse  = "hermes.parse"
+	spanLinks  = "hermes.links"
+	spanScrape = "hermes.scrape"
+	spanStore  = "hermes.store"
+	spanBulk   = "hermes.elasticsearch.bulk"
+)
+
+// tracer returns the tracer of the provider, a tracer that records nothing if it is nil.
+func tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = trace.NewNoopTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// tracer returns the tracer of the Runner's TracerProvider.
+func (r *Runner) tracer() trace.Tracer {
+	return tracer(r.TracerProvider)
+}
+
+// startSpan starts the span of a stage of the handling of a response, as a child of the span of
+// its request.
+func (r *Runner) startSpan(res *http.Response, name string) trace.Span {
+	var ctx context.Context = context.Background()
+	if res.Request != nil {
+		ctx = res.Request.Context()
+	}
+	_, span := r.tracer().Start(ctx, name, trace.WithAttributes(urlAttributes(res.Request)...))
+	return span
+}
+
+// urlAttributes returns the url and host attributes of a request.
+func urlAttributes(req *http.Request) []attribute.KeyValue {
+	if req == nil || req.URL == nil {
+		return nil
+	}
+	return []attribute.KeyValue{
+		attribute.String("url.full", req.URL.String()),
+		attribute.String("server.address", req.URL.Host),
+	}
+}
+
+// endSpan records the error, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceDoer is a fetchbot Doer that traces the requests of the wrapped Doer. The span of a
+// request ends when the body of its response is closed, it is in the context of the request of
+// the response.
+type traceDoer struct {
+	r    *Runner
+	doer fetchbot.Doer
+}
+
+// Do starts the span of the request and does the request.
+func (d *traceDoer) Do(req *http.Request) (*http.Response, error) {
+	name := spanFetch
+	if req.URL.Path == "/robots.txt" {
+		name = spanRobots
+	}
+	ctx, span := d.r.tracer().Start(req.Context(), name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(urlAttributes(req)...),
+		trace.WithAttributes(attribute.String("http.request.method", req.Method)))
+
+	res, err := d.doer.Do(req.WithContext(ctx))
+	if err != nil {
+		endSpan(span, err)
+		return res, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+	res.Body = &countingBody{ReadCloser: res.Body, done: func(n int64) {
+		span.SetAttributes(attribute.Int64("http.response.body.size", n))
+		span.End()
+	}}
+	return res, nil
+}
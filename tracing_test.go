@@ -0,0 +1,248 @@
+package hermes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceDoer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, "hello")
+	}))
+	defer srv.Close()
+
+	for _, c := range []struct {
+		path   string
+		name   string
+		status int
+		size   int64
+		err    bool
+	}{
+		{"/page", spanFetch, 200, 5, false},
+		{"/robots.txt", spanRobots, 200, 5, false},
+		{"/missing", spanFetch, 404, 19, true},
+	} {
+		tp := &memoryTracerProvider{}
+		d := &traceDoer{r: &Runner{TracerProvider: tp}, doer: http.DefaultClient}
+		req, _ := http.NewRequest("GET", srv.URL+c.path, nil)
+		res, err := d.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the span lasts until the body is closed
+		spans := tp.ended(false)
+		if len(spans) != 1 || spans[0].name != c.name {
+			t.Fatalf("%s: spans = %v, want a %s span", c.path, spans, c.name)
+		}
+		if _, err := ioutil.ReadAll(res.Body); err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if len(tp.ended(false)) != 0 {
+			t.Errorf("%s: the span is not ended once the body is closed", c.path)
+		}
+
+		s := spans[0]
+		if s.attr("url.full") != srv.URL+c.path || s.attr("http.request.method") != "GET" {
+			t.Errorf("%s: attributes = %v", c.path, s.attrs)
+		}
+		if s.attr("http.response.status_code") != int64(c.status) || s.attr("http.response.body.size") != c.size {
+			t.Errorf("%s: attributes = %v, want status %d and size %d", c.path, s.attrs, c.status, c.size)
+		}
+		if (s.status == codes.Error) != c.err {
+			t.Errorf("%s: status = %v, want an error %t", c.path, s.status, c.err)
+		}
+	}
+}
+
+func TestTraceDoerError(t *testing.T) {
+	tp := &memoryTracerProvider{}
+	failed := errors.New("connection refused")
+	d := &traceDoer{r: &Runner{TracerProvider: tp}, doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, failed
+	})}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := d.Do(req); err != failed {
+		t.Fatalf("err = %v, want %v", err, failed)
+	}
+	spans := tp.ended(true)
+	if len(spans) != 1 || spans[0].status != codes.Error || len(spans[0].errs) != 1 {
+		t.Errorf("spans = %v, want an ended fetch span with the error", spans)
+	}
+}
+
+func TestCrawlSpans(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><head><title>home</title></head><body><a href="/a">a</a></body></html>`)
+		case "/a":
+			fmt.Fprint(w, `<html><head><title>a</title></head><body><p>a</p></body></html>`)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	tp := &memoryTracerProvider{}
+	r := New()
+	r.URL, _ = url.Parse(srv.URL + "/")
+	r.CrawlDelay = 0
+	r.WorkerIdleTTL = 1
+	r.Throttle = nil
+	r.TracerProvider = tp
+	docs, err := r.Crawl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("%d documents, want 2", len(docs))
+	}
+
+	if open := tp.ended(false); len(open) != 0 {
+		t.Errorf("spans not ended: %v", open)
+	}
+	scrapes := 0
+	for _, s := range tp.ended(true) {
+		if s.name != spanScrape && s.name != spanParse && s.name != spanLinks {
+			continue
+		}
+		if s.name == spanScrape {
+			scrapes++
+		}
+		// the stages of a page are children of its fetch
+		if s.parent == nil || s.parent.name != spanFetch || s.parent.attr("url.full") != s.attr("url.full") {
+			t.Errorf("%s span of %v: parent = %v, want the fetch span of the page", s.name, s.attr("url.full"), s.parent)
+		}
+	}
+	if scrapes != 2 {
+		t.Errorf("%d scrape spans, want 2", scrapes)
+	}
+}
+
+// doerFunc is a fetchbot Doer function.
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// memoryTracerProvider is a TracerProvider that keeps its spans in memory.
+type memoryTracerProvider struct {
+	mu    sync.Mutex
+	spans []*memorySpan
+}
+
+func (tp *memoryTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return memoryTracer{tp}
+}
+
+// ended returns the spans that are ended, or the ones that are not.
+func (tp *memoryTracerProvider) ended(ended bool) []*memorySpan {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	var spans []*memorySpan
+	for _, s := range tp.spans {
+		if s.ended == ended {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+type memoryTracer struct {
+	tp *memoryTracerProvider
+}
+
+func (t memoryTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &memorySpan{tp: t.tp, name: name, attrs: make(map[attribute.Key]interface{})}
+	if parent, ok := trace.SpanFromContext(ctx).(*memorySpan); ok {
+		s.parent = parent
+	}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(cfg.Attributes()...)
+	t.tp.mu.Lock()
+	t.tp.spans = append(t.tp.spans, s)
+	t.tp.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// memorySpan is a span of a memoryTracerProvider, guarded by its mutex.
+type memorySpan struct {
+	tp     *memoryTracerProvider
+	name   string
+	parent *memorySpan
+	attrs  map[attribute.Key]interface{}
+	status codes.Code
+	errs   []error
+	ended  bool
+}
+
+func (s *memorySpan) String() string {
+	return s.name
+}
+
+// attr returns the value of an attribute.
+func (s *memorySpan) attr(k attribute.Key) interface{} {
+	s.tp.mu.Lock()
+	defer s.tp.mu.Unlock()
+	return s.attrs[k]
+}
+
+func (s *memorySpan) End(options ...trace.SpanEndOption) {
+	s.tp.mu.Lock()
+	defer s.tp.mu.Unlock()
+	s.ended = true
+}
+
+func (s *memorySpan) AddEvent(name string, options ...trace.EventOption) {}
+
+func (s *memorySpan) IsRecording() bool {
+	return true
+}
+
+func (s *memorySpan) RecordError(err error, options ...trace.EventOption) {
+	s.tp.mu.Lock()
+	defer s.tp.mu.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *memorySpan) SpanContext() trace.SpanContext {
+	return trace.SpanContext{}
+}
+
+func (s *memorySpan) SetStatus(code codes.Code, description string) {
+	s.tp.mu.Lock()
+	defer s.tp.mu.Unlock()
+	s.status = code
+}
+
+func (s *memorySpan) SetName(name string) {}
+
+func (s *memorySpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.tp.mu.Lock()
+	defer s.tp.mu.Unlock()
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value.AsInterface()
+	}
+}
+
+func (s *memorySpan) TracerProvider() trace.TracerProvider {
+	return s.tp
+}